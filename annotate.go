@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// sourceBeginMarker starts the output of an included template, followed by its name and a NUL byte
+	sourceBeginMarker = "\x00zep-begin:"
+	// sourceEndMarker ends the output of an included template
+	sourceEndMarker = "\x00zep-end\x00"
+)
+
+// commentLines turns text into comment lines, each followed by a newline, using commentPrefix
+// A trailing newline of text does not start another line
+func commentLines(text, commentPrefix string) string {
+	var result strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		result.WriteString(strings.TrimRight(commentPrefix+" "+line, " "))
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// annotateSource prefixes every output line with a comment naming the template that produced it
+// Included templates are recognised by the markers emitted by include, other lines belong to name
+// A line is attributed to the template that wrote its first character
+func annotateSource(output, name, commentPrefix string) string {
+	stack := []string{name}
+	var result, line strings.Builder
+	lineSource := ""
+
+	writeLine := func(newline bool) {
+		if lineSource == "" {
+			lineSource = stack[len(stack)-1]
+		}
+		result.WriteString(strings.TrimRight(commentPrefix+" ["+lineSource+"] "+line.String(), " "))
+		if newline {
+			result.WriteString("\n")
+		}
+		line.Reset()
+		lineSource = ""
+	}
+
+	for len(output) > 0 {
+		switch {
+		case strings.HasPrefix(output, sourceBeginMarker):
+			rest := output[len(sourceBeginMarker):]
+			end := strings.IndexByte(rest, 0)
+			if end < 0 {
+				end = len(rest)
+				rest += "\x00"
+			}
+			stack = append(stack, rest[:end])
+			output = rest[end+1:]
+		case strings.HasPrefix(output, sourceEndMarker):
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			output = output[len(sourceEndMarker):]
+		default:
+			next := len(output)
+			if i := strings.IndexByte(output[1:], 0); i >= 0 {
+				next = i + 1
+			}
+			text := output[:next]
+			output = output[next:]
+			for text != "" {
+				chunk := text
+				newline := strings.IndexByte(text, '\n')
+				if newline >= 0 {
+					chunk = text[:newline]
+				}
+				if lineSource == "" && chunk != "" {
+					lineSource = stack[len(stack)-1]
+				}
+				line.WriteString(chunk)
+				if newline < 0 {
+					break
+				}
+				writeLine(true)
+				text = text[newline+1:]
+			}
+		}
+	}
+	if line.Len() > 0 || lineSource != "" {
+		writeLine(false)
+	}
+	return result.String()
+}
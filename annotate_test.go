@@ -0,0 +1,316 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	env := Environment{"NAME": "World"}
+	templateContent := `{{ define "greeting" }}Hello {{ asString "NAME" }}{{ end }}{{ include "greeting" . }}!`
+
+	got, err := RenderTemplate(templateContent, env)
+	if err != nil {
+		t.Fatalf("RenderTemplate returned error: %v", err)
+	}
+	if got != "Hello World!" {
+		t.Errorf("RenderTemplate = %q, want %q", got, "Hello World!")
+	}
+
+	_, err = RenderTemplate(`{{ include "missing" . }}`, env)
+	if err == nil {
+		t.Errorf("expected error for missing partial")
+	}
+}
+
+func TestIncludeIf(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"nginx.conf.tmpl":         `[{{ includeIf (asBool "ENABLE_TLS") "conf.d/tls.conf.tmpl" }}][{{ includeIf (asBool "DISABLE_GZIP") "conf.d/gzip.conf.tmpl" }}]`,
+		"conf.d/tls.conf.tmpl":    `ssl_certificate {{ .CERT }};{{ includeIf true "ciphers.tmpl" (asString "CIPHERS") }}`,
+		"conf.d/ciphers.tmpl":     ` ssl_ciphers {{ . }};`,
+		"conf.d/gzip.conf.tmpl":   `gzip on;`,
+		"conf.d/missing.tmpl":     `{{ includeIf true "nope.tmpl" }}`,
+		"conf.d/self.tmpl":        `{{ includeIf true "self.tmpl" }}`,
+		"conf.d/two-data.tmpl":    `{{ includeIf true "gzip.conf.tmpl" 1 2 }}`,
+		"conf.d/broken.tmpl":      `{{ includeIf true "broken-part.tmpl" }}`,
+		"conf.d/broken-part.tmpl": `{{ .CERT `,
+	}
+	writeTestFiles(t, dir, files)
+	env := Environment{"ENABLE_TLS": "yes", "DISABLE_GZIP": "no", "CERT": "/etc/cert.pem", "CIPHERS": "HIGH"}
+
+	want := "[ssl_certificate /etc/cert.pem; ssl_ciphers HIGH;][]"
+	for _, html := range []bool{false, true} {
+		var buf strings.Builder
+		if err := renderFileTo(&buf, filepath.Join(dir, "nginx.conf.tmpl"), env, Options{HTML: html}); err != nil {
+			t.Fatalf("renderFileTo returned error: %v", err)
+		}
+		if buf.String() != want {
+			t.Errorf("renderFileTo(HTML: %v) = %q, want %q", html, buf.String(), want)
+		}
+	}
+
+	// paths are resolved against the base directory, an absolute path is used as is
+	got, err := RenderTemplateWithOptions("envTemplate", `{{ includeIf true "conf.d/gzip.conf.tmpl" }}`, env, Options{BaseDir: dir})
+	if err != nil || got != "gzip on;" {
+		t.Errorf("includeIf relative to BaseDir = %q, %v, want %q", got, err, "gzip on;")
+	}
+	got, err = RenderTemplate(`{{ includeIf true "`+filepath.Join(dir, "conf.d", "gzip.conf.tmpl")+`" }}`, env)
+	if err != nil || got != "gzip on;" {
+		t.Errorf("includeIf with absolute path = %q, %v, want %q", got, err, "gzip on;")
+	}
+	if _, err := RenderTemplate(`{{ includeIf false "missing" }}`, env); err != nil {
+		t.Errorf("unexpected error for skipped file: %v", err)
+	}
+
+	errorTests := []struct {
+		file   string
+		wanted string
+	}{
+		{file: "missing.tmpl", wanted: "could not include file 'nope.tmpl'"},
+		{file: "self.tmpl", wanted: "template nesting depth limit of 100 exceeded at 'self.tmpl'"},
+		{file: "two-data.tmpl", wanted: "includeIf takes at most one data argument, got 2"},
+		{file: "broken.tmpl", wanted: "could not parse included file 'broken-part.tmpl'"},
+	}
+	for _, tc := range errorTests {
+		err := renderFileTo(io.Discard, filepath.Join(dir, "conf.d", tc.file), env, Options{})
+		if err == nil || !strings.Contains(err.Error(), tc.wanted) {
+			t.Errorf("renderFileTo(%s) error = %v, want it to contain %q", tc.file, err, tc.wanted)
+		}
+	}
+}
+
+func TestIncludeRecursion(t *testing.T) {
+	env := Environment{}
+
+	// bounded recursion is allowed
+	got, err := RenderTemplate(`{{ define "tree" }}{{ if . }}({{ include "tree" (slice . 1) }}){{ end }}{{ end }}{{ include "tree" "abc" }}`, env)
+	if err != nil || got != "((()))" {
+		t.Errorf("bounded recursion = %q, %v, want %q", got, err, "((()))")
+	}
+
+	_, err = RenderTemplate(`{{ define "loop" }}{{ include "loop" . }}{{ end }}{{ include "loop" . }}`, env)
+	if err == nil || !strings.Contains(err.Error(), "template nesting depth limit of 100 exceeded at 'loop'") {
+		t.Errorf("expected include depth error but got %v", err)
+	}
+	if err != nil && strings.Count(err.Error(), "error calling include") > 1 {
+		t.Errorf("expected the depth error to be reported once but got %v", err)
+	}
+}
+
+func TestTpl(t *testing.T) {
+	env := Environment{
+		"HOST":      "example.com",
+		"PORT":      "8443",
+		"URL":       `https://{{ asString "HOST" }}:{{ asPort "PORT" }}/`,
+		"NESTED":    `{{ tpl (asString "URL") }}api`,
+		"SELF":      `{{ tpl (asString "SELF") }}`,
+		"BROKEN":    `{{ asString "HOST" `,
+		"MISSING":   `{{ asString "NOPE" }}`,
+		"MARKUP":    `<b>{{ asString "TAG" }}</b>`,
+		"TAG":       "a<b",
+		"NO_SYNTAX": "plain",
+	}
+
+	tests := []struct {
+		name            string
+		templateContent string
+		opts            Options
+		want            string
+		wantErr         string
+	}{
+		{name: "value with template syntax", templateContent: `{{ tpl (asString "URL") }}`, want: "https://example.com:8443/"},
+		{name: "nested tpl", templateContent: `{{ asString "NESTED" | tpl }}`, want: "https://example.com:8443/api"},
+		{name: "plain value", templateContent: `{{ tpl (asString "NO_SYNTAX") }}`, want: "plain"},
+		{name: "html not escaped twice", templateContent: `{{ tpl (asString "MARKUP") }}`, opts: Options{HTML: true}, want: "<b>a&lt;b</b>"},
+		{name: "parse error", templateContent: `{{ tpl (asString "BROKEN") }}`, wantErr: "could not parse tpl value"},
+		{name: "execution error", templateContent: `{{ tpl (asString "MISSING") }}`, wantErr: "could not render tpl value"},
+		{name: "recursion", templateContent: `{{ tpl (asString "SELF") }}`, wantErr: "template nesting depth limit of 100 exceeded at 'tpl'"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderTemplateWithOptions("config", tc.templateContent, env, tc.opts)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error containing %q but got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateAnnotateSource(t *testing.T) {
+	env := Environment{"PORT": "8080"}
+	templateContent := `{{ define "server" }}listen {{ asString "PORT" }};
+root /var/www;
+{{ end }}server {
+{{ include "server" . }}}
+`
+	tests := []struct {
+		name          string
+		commentPrefix string
+		wanted        string
+	}{
+		{
+			name:          "hash comment",
+			commentPrefix: "#",
+			wanted:        "# [main.conf] server {\n# [server] listen 8080;\n# [server] root /var/www;\n# [main.conf] }\n",
+		},
+		{
+			name:          "double slash comment",
+			commentPrefix: "//",
+			wanted:        "// [main.conf] server {\n// [server] listen 8080;\n// [server] root /var/www;\n// [main.conf] }\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := Options{AnnotateSource: true, CommentPrefix: tc.commentPrefix}
+			got, err := RenderTemplateWithOptions("main.conf", templateContent, env, opts)
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.wanted {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_annotateSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		wanted string
+	}{
+		{name: "plain output", output: "a\nb", wanted: "# [main] a\n# [main] b"},
+		{name: "empty line", output: "a\n\nb\n", wanted: "# [main] a\n# [main]\n# [main] b\n"},
+		{
+			name:   "inline partial",
+			output: "x=" + sourceBeginMarker + "part\x00" + "1" + sourceEndMarker + "\n",
+			wanted: "# [main] x=1\n",
+		},
+		{
+			name:   "nested partials",
+			output: sourceBeginMarker + "outer\x00" + "o\n" + sourceBeginMarker + "inner\x00" + "i\n" + sourceEndMarker + sourceEndMarker + "m\n",
+			wanted: "# [outer] o\n# [inner] i\n# [main] m\n",
+		},
+		{name: "empty output", output: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := annotateSource(tc.output, "main", "#")
+			if got != tc.wanted {
+				t.Errorf("annotateSource(%q) = %q, want %q", tc.output, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func TestRunAnnotateSource(t *testing.T) {
+	tempDir := t.TempDir()
+
+	templatePath := filepath.Join(tempDir, "app.conf")
+	templateContent := "{{ define \"part\" }}from partial\n{{ end }}from main\n{{ include \"part\" . }}"
+	err := os.WriteFile(templatePath, []byte(templateContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	output, err := Run([]string{"zep", "--annotate-source", "--annotate-comment=;", templatePath}, []string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedOutput := "; [app.conf] from main\n; [part] from partial\n"
+	if output != expectedOutput {
+		t.Errorf("Expected output %q but got %q", expectedOutput, output)
+	}
+
+	_, err = Run([]string{"zep", "--unknown", templatePath}, []string{})
+	if err == nil {
+		t.Errorf("Expected error for unknown option but got none")
+	}
+}
+
+func Test_commentLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		prefix string
+		wanted string
+	}{
+		{name: "single line", text: "generated", prefix: "#", wanted: "# generated\n"},
+		{name: "trailing newline", text: "generated\n", prefix: "#", wanted: "# generated\n"},
+		{name: "multiple lines", text: "generated\ndo not edit", prefix: "//", wanted: "// generated\n// do not edit\n"},
+		{name: "blank line", text: "a\n\nb", prefix: ";", wanted: "; a\n;\n; b\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := commentLines(tc.text, tc.prefix); got != tc.wanted {
+				t.Errorf("commentLines(%q, %q) = %q, want %q", tc.text, tc.prefix, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateBanner(t *testing.T) {
+	env := Environment{"NAME": "zep"}
+	opts := Options{Banner: "Generated by {{ .NAME }}\ndo not edit", CommentPrefix: "#"}
+
+	for _, o := range []Options{opts, {Banner: opts.Banner, CommentPrefix: "#", Trim: true}, {Banner: opts.Banner, CommentPrefix: "#", AnnotateSource: true}} {
+		got, err := RenderTemplateWithOptions("app.conf", "\nkey=value\n", env, o)
+		if err != nil {
+			t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+		}
+		if !strings.HasPrefix(got, "# Generated by zep\n# do not edit\n") {
+			t.Errorf("Expected the banner first but got %q", got)
+		}
+		if strings.Count(got, "Generated by") != 1 {
+			t.Errorf("Expected the banner exactly once but got %q", got)
+		}
+	}
+
+	got, err := RenderTemplateWithOptions("app.conf", "x", env, Options{Banner: "{{ now.Year }}", CommentPrefix: "//"})
+	if err != nil || !strings.HasPrefix(got, "// 20") {
+		t.Errorf("Expected now to be expanded in the banner but got %q, %v", got, err)
+	}
+
+	if _, err := RenderTemplateWithOptions("app.conf", "x", env, Options{Banner: "{{ asString \"MISSING\" }}"}); err == nil || !strings.Contains(err.Error(), "banner") {
+		t.Errorf("Expected banner error but got %v", err)
+	}
+}
+
+func TestRunBanner(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	if err := os.WriteFile(templatePath, []byte(`{{ define "part" }}part{{ end }}{{ include "part" . }} {{ tpl "x" }}`), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	output, err := Run([]string{"zep", "--banner", "Generated by zep", "--comment-prefix", ";", templatePath}, []string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "; Generated by zep\npart x" {
+		t.Errorf("Unexpected output %q", output)
+	}
+
+	output, err = Run([]string{"zep", templatePath}, []string{})
+	if err != nil || output != "part x" {
+		t.Errorf("Expected no banner by default but got %q, %v", output, err)
+	}
+}
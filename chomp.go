@@ -0,0 +1,66 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// chompKeywords are the actions that produce no output of their own
+var chompKeywords = map[string]bool{
+	"if": true, "else": true, "end": true, "range": true, "with": true,
+	"define": true, "block": true, "break": true, "continue": true,
+}
+
+// chompAssignment matches a variable declaration or assignment such as $x := 1
+var chompAssignment = regexp.MustCompile(`^\$\w*\s*:?=`)
+
+// chompActions makes lines that consist solely of a control action emit nothing, like trim_blocks and
+// lstrip_blocks of Jinja. A line is chomped when, apart from spaces and tabs around it, it contains a single
+// {{ ... }} action that is one of if, else, end, range, with, define, block, break, continue, a comment or a
+// variable assignment. The indentation before and the newline after such an action are moved into template
+// comments, so the line emits nothing while error messages keep their line numbers.
+// Actions spanning several lines, lines with more than one action and actions that produce output,
+// including template, are left untouched.
+func chompActions(templateContent string) string {
+	var result strings.Builder
+	for _, line := range strings.SplitAfter(templateContent, "\n") {
+		body, ok := strings.CutSuffix(line, "\n")
+		if !ok {
+			result.WriteString(line)
+			continue
+		}
+		newline := "\n"
+		if trimmed, ok := strings.CutSuffix(body, "\r"); ok {
+			body = trimmed
+			newline = "\r\n"
+		}
+		action := strings.TrimLeft(body, " \t")
+		indent := body[:len(body)-len(action)]
+		action = strings.TrimRight(action, " \t")
+		if !isStandaloneControlAction(action) {
+			result.WriteString(line)
+			continue
+		}
+		if indent != "" {
+			result.WriteString("{{/*" + indent + "*/}}")
+		}
+		result.WriteString(action)
+		result.WriteString("{{/*" + body[len(indent)+len(action):] + newline + "*/}}")
+	}
+	return result.String()
+}
+
+// isStandaloneControlAction reports whether the text is a single action that produces no output
+func isStandaloneControlAction(text string) bool {
+	if !strings.HasPrefix(text, "{{") || !strings.HasSuffix(text, "}}") || strings.Count(text, "{{") != 1 {
+		return false
+	}
+	inner := strings.TrimSuffix(strings.TrimPrefix(text, "{{"), "}}")
+	inner = strings.TrimSuffix(strings.TrimPrefix(inner, "-"), "-")
+	inner = strings.TrimSpace(inner)
+	if strings.HasPrefix(inner, "/*") || chompAssignment.MatchString(inner) {
+		return true
+	}
+	keyword, _, _ := strings.Cut(inner, " ")
+	return chompKeywords[keyword]
+}
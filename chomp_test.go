@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsStandaloneControlAction(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: `{{ if .DEBUG }}`, want: true},
+		{text: `{{else}}`, want: true},
+		{text: `{{ else if .X }}`, want: true},
+		{text: `{{- end -}}`, want: true},
+		{text: `{{ range $i, $h := .HOSTS }}`, want: true},
+		{text: `{{ define "item" }}`, want: true},
+		{text: `{{/* comment */}}`, want: true},
+		{text: `{{- /* comment */ -}}`, want: true},
+		{text: `{{ $port := asPortOr "PORT" 80 }}`, want: true},
+		{text: `{{ $port = 81 }}`, want: true},
+		{text: `{{ .NAME }}`, want: false},
+		{text: `{{ template "item" . }}`, want: false},
+		{text: `{{ ifx }}`, want: false},
+		{text: `{{ if .A }}{{ end }}`, want: false},
+		{text: `x {{ end }}`, want: false},
+		{text: `{{ if .A`, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			got := isStandaloneControlAction(tc.text)
+			if got != tc.want {
+				t.Errorf("isStandaloneControlAction(%q) = %v, want %v", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateChomp(t *testing.T) {
+	env := Environment{"HOSTS": "a,b", "DEBUG": "true"}
+
+	tests := []struct {
+		name            string
+		templateContent string
+		want            string
+	}{
+		{
+			name:            "range",
+			templateContent: "upstream {\n  {{ range asStringSlice \"HOSTS\" \",\" }}\n  server {{ . }};\n  {{ end }}\n}\n",
+			want:            "upstream {\n  server a;\n  server b;\n}\n",
+		},
+		{
+			name:            "if else",
+			templateContent: "{{ if asBool \"DEBUG\" }}\nlevel debug;\n{{ else }}\nlevel info;\n{{ end }}\ndone\n",
+			want:            "level debug;\ndone\n",
+		},
+		{
+			name:            "assignment and comment",
+			templateContent: "{{/* defaults */}}\n{{ $h := \"x\" }}\nhost {{ $h }}\n",
+			want:            "host x\n",
+		},
+		{
+			name:            "crlf",
+			templateContent: "{{ if true }}\r\nyes\r\n{{ end }}\r\n",
+			want:            "yes\r\n",
+		},
+		{
+			name:            "output actions untouched",
+			templateContent: "{{ asString \"HOSTS\" }}\n{{ if true }}x{{ end }}\n",
+			want:            "a,b\nx\n",
+		},
+		{
+			name:            "last line without newline",
+			templateContent: "{{ if true }}\nyes\n{{ end }}",
+			want:            "yes\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderTemplateWithOptions("config", tc.templateContent, env, Options{Chomp: true})
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// line numbers of errors are not shifted by chomping
+	_, err := RenderTemplateWithOptions("config", "{{ if true }}\n  {{ end }}\n{{ end }}\n", env, Options{Chomp: true})
+	if err == nil || !contains(err.Error(), "config:3:") {
+		t.Errorf("expected error on line 3 but got %v", err)
+	}
+}
+
+func TestRunChomp(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	if err := os.WriteFile(templatePath, []byte("{{ if true }}\n{{ .NAME }}\n{{ end }}\n"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	output, err := Run([]string{"zep", "--chomp", templatePath}, []string{"NAME=zep"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "zep\n" {
+		t.Errorf("Expected output %q but got %q", "zep\n", output)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change of a unified diff
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' keeps, '-' deletes and '+' inserts the line
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits text into lines that keep their newline, only the last line may lack one
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxDiffLines limits the combined number of lines of the two texts unifiedDiff compares, since the
+// running time grows with the product of the input size and the number of differences
+const maxDiffLines = 20000
+
+// errDiffTooLarge is returned by unifiedDiff for texts with more than maxDiffLines lines together
+var errDiffTooLarge = fmt.Errorf("cannot diff more than %d lines", maxDiffLines)
+
+// differ computes an edit script with the linear space variant of the algorithm of Myers,
+// which splits the texts at the middle of a shortest edit path and recurses on both halves
+type differ struct {
+	a, b []string
+	// forward and backward hold the furthest reaching x of each diagonal, reused across splits
+	forward, backward []int
+	offset            int
+	ops               []diffOp
+}
+
+// diffLines returns a shortest edit script that turns a into b. Within a run of changes all
+// deletions come before the insertions, as in the output of diff -u
+func diffLines(a, b []string) []diffOp {
+	size := len(a) + len(b)
+	d := &differ{
+		a:        a,
+		b:        b,
+		forward:  make([]int, 2*size+3),
+		backward: make([]int, 2*size+3),
+		offset:   size + 1,
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	// order each run of changes as deletions followed by insertions
+	for i := 0; i < len(d.ops); {
+		if d.ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(d.ops) && d.ops[j].kind != ' ' {
+			j++
+		}
+		slices.SortStableFunc(d.ops[i:j], func(x, y diffOp) int {
+			return int(y.kind) - int(x.kind)
+		})
+		i = j
+	}
+	return d.ops
+}
+
+// compare appends the edit script that turns a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, diffOp{' ', d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.ops = append(d.ops, diffOp{'+', line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.ops = append(d.ops, diffOp{'-', line})
+		}
+	default:
+		// both ranges are non-empty and differ at both ends, so at least two edits are needed
+		// and the split point leaves at least one on either side
+		x, y := d.split(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+
+	for i := range suffix {
+		d.ops = append(d.ops, diffOp{' ', d.a[aHi+i]})
+	}
+}
+
+// split returns a point on a shortest edit path from (aLo, bLo) to (aHi, bHi) that lies in the middle
+// of it, found by searching forward from the start and backward from the end until the two meet
+func (d *differ) split(aLo, aHi, bLo, bHi int) (int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	forward, backward, offset := d.forward, d.backward, d.offset
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// the backward diagonal delta-k was reached in step-1 steps
+			if delta%2 != 0 && delta-k >= -(step-1) && delta-k <= step-1 && x+backward[offset+delta-k] >= n {
+				return aLo + x, bLo + y
+			}
+		}
+		// the backward search runs forward on the reversed ranges
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if delta%2 == 0 && delta-k >= -step && delta-k <= step && x+forward[offset+delta-k] >= n {
+				return aHi - x, bHi - y
+			}
+		}
+	}
+	panic("diff: no middle of the edit path found")
+}
+
+// unifiedDiff returns the changes from oldText to newText in unified diff format with
+// diffContext lines of context, or an empty string if both are equal.
+// It returns errDiffTooLarge if the texts have more than maxDiffLines lines together
+func unifiedDiff(oldName, newName, oldText, newText string) (string, error) {
+	oldLines, newLines := splitLines(oldText), splitLines(newText)
+	if len(oldLines)+len(newLines) > maxDiffLines {
+		return "", errDiffTooLarge
+	}
+	ops := diffLines(oldLines, newLines)
+
+	// line numbers in the old and new text before each operation
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	var changes []int
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.kind != '-' {
+			newLine[i+1]++
+		}
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	for c := 0; c < len(changes); {
+		start := max(changes[c]-diffContext, 0)
+		last := changes[c]
+		// merge changes whose context would overlap into one hunk
+		for c++; c < len(changes) && changes[c]-last <= 2*diffContext+1; c++ {
+			last = changes[c]
+		}
+		end := min(last+diffContext+1, len(ops))
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]-oldLine[start]),
+			hunkRange(newLine[start], newLine[end]-newLine[start]))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+// hunkRange formats the 0-based start line and line count of a hunk the way diff -u does
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
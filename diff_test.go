@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{name: "equal", oldText: "a\nb\n", newText: "a\nb\n", want: ""},
+		{name: "both empty", oldText: "", newText: "", want: ""},
+		{
+			name:    "changed line",
+			oldText: "a\nb\nc\n",
+			newText: "a\nB\nc\n",
+			want:    "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:    "new file",
+			oldText: "",
+			newText: "a\nb\n",
+			want:    "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "removed everything",
+			oldText: "a\n",
+			newText: "",
+			want:    "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:    "missing final newline",
+			oldText: "a\nb\n",
+			newText: "a\nb",
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "context is limited",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			newText: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want:    "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:    "separate hunks",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			newText: "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name:    "close changes share a hunk",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			newText: "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want:    "--- old\n+++ new\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name:    "insertion",
+			oldText: "a\nc\n",
+			newText: "a\nb\nc\n",
+			want:    "--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := unifiedDiff("old", "new", tc.oldText, tc.newText)
+			if err != nil || got != tc.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s, error: %v", got, tc.want, err)
+			}
+		})
+	}
+
+	if _, err := unifiedDiff("old", "new", strings.Repeat("a\n", maxDiffLines), "b\n"); !errors.Is(err, errDiffTooLarge) {
+		t.Errorf("Expected errDiffTooLarge for %d lines but got %v", maxDiffLines+1, err)
+	}
+}
+
+func TestDiffLinesShortest(t *testing.T) {
+	// lcsLength is the length of the longest common subsequence, a shortest edit script keeps exactly that many lines
+	lcsLength := func(a, b []string) int {
+		dp := make([][]int, len(a)+1)
+		for i := range dp {
+			dp[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					dp[i][j] = dp[i+1][j+1] + 1
+				} else {
+					dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+				}
+			}
+		}
+		return dp[0][0]
+	}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomLines := func() []string {
+		lines := make([]string, rng.IntN(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.IntN(4)))
+		}
+		return lines
+	}
+	for range 500 {
+		a, b := randomLines(), randomLines()
+		ops := diffLines(a, b)
+
+		var gotA, gotB []string
+		kept := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind == ' ' {
+				kept++
+			}
+		}
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("diffLines(%q, %q) = %v does not turn one into the other", a, b, ops)
+		}
+		if want := lcsLength(a, b); kept != want {
+			t.Fatalf("diffLines(%q, %q) keeps %d lines, a shortest script keeps %d", a, b, kept, want)
+		}
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "current.conf")
+	if err := os.WriteFile(templatePath, []byte("listen {{ .PORT }};\n"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+	if err := os.WriteFile(outputPath, []byte("listen 80;\n"), 0644); err != nil {
+		t.Fatalf("Failed to create output file: %v", err)
+	}
+
+	var stdout strings.Builder
+	err := RunTo(&stdout, []string{"zep", "--diff", "-o", outputPath, templatePath}, []string{"PORT=8080"})
+	if !errors.Is(err, errDiffFound) {
+		t.Errorf("Expected errDiffFound but got %v", err)
+	}
+	want := "--- " + outputPath + "\n+++ " + outputPath + " (rendered)\n@@ -1 +1 @@\n-listen 80;\n+listen 8080;\n"
+	if stdout.String() != want {
+		t.Errorf("Expected diff %q but got %q", want, stdout.String())
+	}
+	data, _ := os.ReadFile(outputPath)
+	if string(data) != "listen 80;\n" {
+		t.Errorf("Expected output file to be unchanged but got %q", data)
+	}
+
+	stdout.Reset()
+	if err := RunTo(&stdout, []string{"zep", "--diff", "-o", outputPath, templatePath}, []string{"PORT=80"}); err != nil {
+		t.Errorf("Expected no error for identical output but got %v", err)
+	}
+	if stdout.String() != "" {
+		t.Errorf("Expected no diff but got %q", stdout.String())
+	}
+
+	if _, err := Run([]string{"zep", "--diff", templatePath}, []string{"PORT=80"}); err == nil {
+		t.Errorf("Expected usage error for --diff without -o but got none")
+	}
+}
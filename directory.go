@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templateExtension marks the files of a template directory that are rendered
+const templateExtension = ".tmpl"
+
+// RenderDirectory walks srcDir and mirrors its structure into outDir.
+// Files ending in .tmpl are rendered with the environment and written without the extension,
+// all other files are copied as-is. Directories and files keep the permissions of their source.
+// Rendering stops at the first error, files written before the error are left in place.
+// With opts.ContinueOnError the remaining files are still processed and the errors of all
+// failed files are returned joined, one per line.
+// It returns the paths of the written files relative to outDir.
+func RenderDirectory(srcDir, outDir string, env Environment, opts Options) ([]string, error) {
+	return renderDirectory(srcDir, outDir, env, opts, true)
+}
+
+// renderDirectory implements RenderDirectory, with write unset templates are rendered and
+// all other files are only listed, nothing is written to outDir
+func renderDirectory(srcDir, outDir string, env Environment, opts Options, write bool) ([]string, error) {
+	var written []string
+	var errs []error
+	// dirs are the created directories, they get the permissions of their source after the walk
+	type createdDir struct {
+		rel  string
+		perm fs.FileMode
+	}
+	var dirs []createdDir
+	// fail stops the walk with err, or records it and carries on with opts.ContinueOnError
+	fail := func(err error, skip error) error {
+		if !opts.ContinueOnError {
+			return err
+		}
+		errs = append(errs, err)
+		return skip
+	}
+
+	opts.BaseDir = srcDir
+	r := newTemplateRenderer(env, opts)
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fail(err, nil)
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fail(err, nil)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fail(err, nil)
+		}
+
+		if d.IsDir() {
+			if !write {
+				return nil
+			}
+			// a read-only source directory must stay writable until its files are written
+			if err := os.MkdirAll(filepath.Join(outDir, rel), 0755); err != nil {
+				// nothing below a directory that could not be created can be written
+				return fail(fmt.Errorf("could not create directory for '%s': %v", rel, err), fs.SkipDir)
+			}
+			dirs = append(dirs, createdDir{rel: rel, perm: info.Mode().Perm()})
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if !strings.HasSuffix(rel, templateExtension) {
+			if !write {
+				written = append(written, rel)
+				return nil
+			}
+			if err := copyFile(path, filepath.Join(outDir, rel), info.Mode().Perm()); err != nil {
+				return fail(err, nil)
+			}
+			written = append(written, rel)
+			return nil
+		}
+
+		templateContent, err := os.ReadFile(path)
+		if err != nil {
+			return fail(fmt.Errorf("error reading template file '%s': %v", rel, err), nil)
+		}
+		var output bytes.Buffer
+		if err := r.render(&output, rel, string(templateContent)); err != nil {
+			return fail(fmt.Errorf("error rendering template '%s': %w", rel, &templateError{name: rel, source: string(templateContent), err: err}), nil)
+		}
+		target := strings.TrimSuffix(rel, templateExtension)
+		if !write {
+			written = append(written, target)
+			return nil
+		}
+		if err := os.WriteFile(filepath.Join(outDir, target), output.Bytes(), info.Mode().Perm()); err != nil {
+			return fail(fmt.Errorf("could not write file '%s': %v", target, err), nil)
+		}
+		// os.WriteFile only applies the permissions to a file it creates
+		if err := os.Chmod(filepath.Join(outDir, target), info.Mode().Perm()); err != nil {
+			return fail(fmt.Errorf("could not set permissions of file '%s': %v", target, err), nil)
+		}
+		written = append(written, target)
+		return nil
+	})
+	// deepest first, so a read-only directory does not keep its subdirectories from being changed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if chmodErr := os.Chmod(filepath.Join(outDir, dirs[i].rel), dirs[i].perm); chmodErr != nil && err == nil {
+			err = fail(fmt.Errorf("could not set permissions of directory '%s': %v", dirs[i].rel, chmodErr), nil)
+		}
+	}
+	if err != nil {
+		return written, err
+	}
+	return written, errors.Join(errs...)
+}
+
+// copyFile copies the source file to the destination path and sets the given permissions,
+// also if the destination already existed
+func copyFile(source, destination string, perm fs.FileMode) error {
+	r, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("could not open file '%s': %v", source, err)
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("could not open file '%s': %v", destination, err)
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("could not copy file '%s' to '%s': %v", source, destination, err)
+	}
+	if err := w.Chmod(perm); err != nil {
+		return fmt.Errorf("could not set permissions of file '%s': %v", destination, err)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+}
+
+func TestRenderDirectory(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := filepath.Join(t.TempDir(), "rendered")
+
+	writeTestFiles(t, srcDir, map[string]string{
+		"app.conf.tmpl":           "name={{ asString \"NAME\" }}",
+		"nginx/site.conf.tmpl":    "server_name {{ asString \"HOST\" }};",
+		"nginx/mime.types":        "text/html html;",
+		"scripts/entrypoint.tmpl": "#!/bin/sh\necho {{ .NAME }}",
+	})
+	if err := os.Chmod(filepath.Join(srcDir, "scripts", "entrypoint.tmpl"), 0755); err != nil {
+		t.Fatalf("Failed to chmod file: %v", err)
+	}
+
+	env := Environment{"NAME": "zep", "HOST": "example.com"}
+	written, err := RenderDirectory(srcDir, outDir, env, Options{})
+	if err != nil {
+		t.Fatalf("RenderDirectory returned error: %v", err)
+	}
+
+	wantWritten := []string{
+		"app.conf",
+		filepath.Join("nginx", "mime.types"),
+		filepath.Join("nginx", "site.conf"),
+		filepath.Join("scripts", "entrypoint"),
+	}
+	if !reflect.DeepEqual(written, wantWritten) {
+		t.Errorf("RenderDirectory wrote %v, want %v", written, wantWritten)
+	}
+
+	wantContent := map[string]string{
+		"app.conf":           "name=zep",
+		"nginx/site.conf":    "server_name example.com;",
+		"nginx/mime.types":   "text/html html;",
+		"scripts/entrypoint": "#!/bin/sh\necho zep",
+	}
+	for name, want := range wantContent {
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", name, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(outDir, "scripts", "entrypoint"))
+	if err != nil {
+		t.Fatalf("Failed to stat rendered file: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("Expected permissions 0755 but got %o", info.Mode().Perm())
+	}
+}
+
+func TestRenderDirectorySharedFunctions(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+
+	// both templates define the same partial, each must only see its own
+	writeTestFiles(t, srcDir, map[string]string{
+		"a.tmpl": `{{ define "item" }}a:{{ . }}{{ end }}{{ include "item" (asString "NAME") }}`,
+		"b.tmpl": `{{ define "item" }}b:{{ . }}{{ end }}{{ include "item" (asString "NAME") }}`,
+		"c.tmpl": `{{ withSource "NAME" }}`,
+	})
+
+	env := Environment{"NAME": "zep"}
+	if _, err := RenderDirectory(srcDir, outDir, env, Options{IncludeEnvComments: true, CommentPrefix: "#"}); err != nil {
+		t.Fatalf("RenderDirectory returned error: %v", err)
+	}
+
+	wantContent := map[string]string{
+		"a": "a:zep",
+		"b": "b:zep",
+		"c": "zep # from NAME",
+	}
+	for name, want := range wantContent {
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", name, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+}
+
+func BenchmarkRenderDirectory(b *testing.B) {
+	srcDir := b.TempDir()
+	outDir := b.TempDir()
+	for i := range 100 {
+		content := fmt.Sprintf("listen {{ asPortOr \"PORT\" 8080 }};\nserver_name {{ asString \"HOST\" }}-%d;\n", i)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("site%03d.conf.tmpl", i)), []byte(content), 0644); err != nil {
+			b.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	env := Environment{"HOST": "example.com", "PORT": "80"}
+
+	for b.Loop() {
+		if _, err := RenderDirectory(srcDir, outDir, env, Options{}); err != nil {
+			b.Fatalf("RenderDirectory returned error: %v", err)
+		}
+	}
+}
+
+func TestRenderDirectoryIncludeIf(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+	writeTestFiles(t, srcDir, map[string]string{
+		"nginx/site.conf.tmpl":    `server { {{ includeIf true "partials/tls.part" }} }`,
+		"nginx/partials/tls.part": "ssl on;",
+	})
+
+	if _, err := RenderDirectory(srcDir, outDir, Environment{}, Options{}); err != nil {
+		t.Fatalf("RenderDirectory returned error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "nginx", "site.conf"))
+	if err != nil || string(data) != "server { ssl on; }" {
+		t.Errorf("site.conf = %q, %v, want %q", data, err, "server { ssl on; }")
+	}
+}
+
+func TestRenderDirectoryError(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+
+	writeTestFiles(t, srcDir, map[string]string{
+		"a.conf.tmpl": "ok",
+		"b.conf.tmpl": "{{ asString \"MISSING\" }}",
+		"c.conf.tmpl": "not reached",
+	})
+
+	written, err := RenderDirectory(srcDir, outDir, Environment{}, Options{})
+	if err == nil {
+		t.Fatalf("Expected error for failing template but got none")
+	}
+	if !strings.Contains(err.Error(), "b.conf.tmpl") {
+		t.Errorf("Expected error to name the failing template but got %q", err.Error())
+	}
+	if !reflect.DeepEqual(written, []string{"a.conf"}) {
+		t.Errorf("Expected only a.conf to be written but got %v", written)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "c.conf")); !os.IsNotExist(err) {
+		t.Errorf("Expected c.conf not to be written")
+	}
+}
+
+func TestRenderDirectoryContinueOnError(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+
+	writeTestFiles(t, srcDir, map[string]string{
+		"a.conf.tmpl":     "ok",
+		"b.conf.tmpl":     "{{ asString \"MISSING\" }}",
+		"c.conf.tmpl":     "{{ if }}",
+		"d.conf.tmpl":     "also ok",
+		"static/raw.conf": "copied",
+	})
+
+	written, err := RenderDirectory(srcDir, outDir, Environment{}, Options{ContinueOnError: true})
+	if err == nil {
+		t.Fatalf("Expected error for failing templates but got none")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "b.conf.tmpl") || !strings.Contains(lines[1], "c.conf.tmpl") {
+		t.Errorf("Expected one error line per failing template but got %q", err.Error())
+	}
+	wantWritten := []string{"a.conf", "d.conf", filepath.Join("static", "raw.conf")}
+	if !reflect.DeepEqual(written, wantWritten) {
+		t.Errorf("RenderDirectory wrote %v, want %v", written, wantWritten)
+	}
+	for _, name := range []string{"b.conf", "c.conf"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to be written", name)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(outDir, "d.conf")); err != nil || string(data) != "also ok" {
+		t.Errorf("d.conf = %q, %v", data, err)
+	}
+}
+
+func TestRunDirectory(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+	writeTestFiles(t, srcDir, map[string]string{"app.conf.tmpl": "name={{ .NAME }}"})
+
+	output, err := Run([]string{"zep", "--dir", srcDir, "--out=" + outDir}, []string{"NAME=zep"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "app.conf" {
+		t.Errorf("Expected output %q but got %q", "app.conf", output)
+	}
+
+	invalidArgs := [][]string{
+		{"zep", "--dir", srcDir},
+		{"zep", "--out", outDir},
+		{"zep", "--dir", srcDir, "--out", outDir, "template.txt"},
+		{"zep", "--dir"},
+		{"zep", "--continue-on-error", "template.txt"},
+	}
+	for _, args := range invalidArgs {
+		if _, err := Run(args, []string{}); err == nil {
+			t.Errorf("Expected error for arguments %v but got none", args)
+		}
+	}
+
+	if _, err := Run([]string{"zep", "--dir", filepath.Join(srcDir, "missing"), "--out", outDir}, []string{}); err == nil {
+		t.Errorf("Expected error for missing template directory but got none")
+	}
+
+	writeTestFiles(t, srcDir, map[string]string{"bad.conf.tmpl": "{{ asString \"MISSING\" }}", "other.conf.tmpl": "{{ .NAME }}"})
+	var stdout strings.Builder
+	err = RunTo(&stdout, []string{"zep", "--continue-on-error", "--dir", srcDir, "--out", outDir}, []string{"NAME=zep"})
+	if err == nil || !strings.Contains(err.Error(), "bad.conf.tmpl") {
+		t.Errorf("Expected error naming bad.conf.tmpl but got %v", err)
+	}
+	if stdout.String() != "app.conf\nother.conf\n" {
+		t.Errorf("Expected the written files to be listed but got %q", stdout.String())
+	}
+	if _, err := Run([]string{"zep", "--dir", srcDir, "--out", outDir}, []string{"NAME=zep"}); err == nil || strings.Count(err.Error(), "error rendering template") != 1 {
+		t.Errorf("Expected a single error without --continue-on-error but got %v", err)
+	}
+}
+
+func TestRenderDirectoryPermissions(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+	writeTestFiles(t, srcDir, map[string]string{
+		"locked/app.conf.tmpl": "name={{ .NAME }}",
+		"locked/static.txt":    "static",
+	})
+	for name, perm := range map[string]os.FileMode{"locked/app.conf.tmpl": 0600, "locked/static.txt": 0640} {
+		if err := os.Chmod(filepath.Join(srcDir, name), perm); err != nil {
+			t.Fatalf("Failed to chmod file: %v", err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(srcDir, "locked"), 0555); err != nil {
+		t.Fatalf("Failed to chmod directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(filepath.Join(srcDir, "locked"), 0755) })
+
+	// existing outputs get the permissions of their source too
+	writeTestFiles(t, outDir, map[string]string{"locked/app.conf": "old", "locked/static.txt": "old"})
+	for _, name := range []string{"locked/app.conf", "locked/static.txt"} {
+		if err := os.Chmod(filepath.Join(outDir, name), 0666); err != nil {
+			t.Fatalf("Failed to chmod file: %v", err)
+		}
+	}
+	t.Cleanup(func() { os.Chmod(filepath.Join(outDir, "locked"), 0755) })
+
+	if _, err := RenderDirectory(srcDir, outDir, Environment{"NAME": "zep"}, Options{}); err != nil {
+		t.Fatalf("RenderDirectory returned error: %v", err)
+	}
+
+	wantPerm := map[string]os.FileMode{"locked": 0555, "locked/app.conf": 0600, "locked/static.txt": 0640}
+	for name, want := range wantPerm {
+		info, err := os.Stat(filepath.Join(outDir, name))
+		if err != nil {
+			t.Errorf("Failed to stat %s: %v", name, err)
+			continue
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("Expected permissions %o for %s but got %o", want, name, info.Mode().Perm())
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(outDir, "locked", "app.conf")); err != nil || string(data) != "name=zep" {
+		t.Errorf("app.conf = %q, %v, want %q", data, err, "name=zep")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// dotEnvSafe lists the characters a value may consist of to be written without quotes
+const dotEnvSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,:/@%+="
+
+// dotEnvValue formats a value for a .env file. Values made only of letters, digits and _-.,:/@%+=
+// are written as is, an empty value stays empty and any other value is single quoted like shellQuote,
+// so spaces, quotes, $, backslashes and newlines are all kept literally.
+func dotEnvValue(s string) string {
+	if strings.Trim(s, dotEnvSafe) == "" {
+		return s
+	}
+	return shellQuote(s)
+}
+
+// WriteDotEnv writes the environment to w as a .env file with one KEY=value line per variable,
+// sorted by key. The file can be read back by sourcing it with a POSIX shell.
+// Keys that are not valid shell variable names, like the BASH_FUNC_name%% entries bash exports
+// for functions, are skipped; if warnings is not nil, each skipped key is reported to it.
+func (env Environment) WriteDotEnv(w io.Writer, warnings io.Writer) error {
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		if !envVarName.MatchString(k) {
+			if warnings != nil {
+				fmt.Fprintf(warnings, "warning: skipping '%s', it is not a valid variable name\n", k)
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, dotEnvValue(env[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
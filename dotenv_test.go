@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_dotEnvValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "plain", value: "localhost", wanted: "localhost"},
+		{name: "url", value: "https://user@example.com:8080/a,b", wanted: "https://user@example.com:8080/a,b"},
+		{name: "empty", value: "", wanted: ""},
+		{name: "space", value: "hello world", wanted: "'hello world'"},
+		{name: "dollar", value: "$HOME", wanted: "'$HOME'"},
+		{name: "single quote", value: "it's", wanted: `'it'\''s'`},
+		{name: "double quote", value: `say "hi"`, wanted: `'say "hi"'`},
+		{name: "newline", value: "a\nb", wanted: "'a\nb'"},
+		{name: "hash", value: "#comment", wanted: "'#comment'"},
+		{name: "tilde", value: "~/dir", wanted: "'~/dir'"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dotEnvValue(tc.value); got != tc.wanted {
+				t.Errorf("dotEnvValue(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func TestWriteDotEnv(t *testing.T) {
+	env := Environment{"PORT": "8080", "GREETING": "hello world", "EMPTY": ""}
+	var buf strings.Builder
+	if err := env.WriteDotEnv(&buf, nil); err != nil {
+		t.Fatalf("WriteDotEnv returned error: %v", err)
+	}
+	if buf.String() != "EMPTY=\nGREETING='hello world'\nPORT=8080\n" {
+		t.Errorf("WriteDotEnv wrote %q", buf.String())
+	}
+
+	buf.Reset()
+	var warnings strings.Builder
+	env = Environment{"A": "1", "NOT-VALID": "x", "BASH_FUNC_greet%%": "() {  echo hi\n}", "Z": "2"}
+	if err := env.WriteDotEnv(&buf, &warnings); err != nil {
+		t.Fatalf("WriteDotEnv returned error: %v", err)
+	}
+	if buf.String() != "A=1\nZ=2\n" {
+		t.Errorf("Expected invalid keys to be skipped but got %q", buf.String())
+	}
+	wantWarnings := "warning: skipping 'BASH_FUNC_greet%%', it is not a valid variable name\n" +
+		"warning: skipping 'NOT-VALID', it is not a valid variable name\n"
+	if warnings.String() != wantWarnings {
+		t.Errorf("WriteDotEnv warned %q, want %q", warnings.String(), wantWarnings)
+	}
+}
+
+func TestWriteDotEnvRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	env := Environment{
+		"PLAIN":   "value",
+		"SPACES":  "  padded value  ",
+		"QUOTES":  `it's "quoted"`,
+		"SPECIAL": "$HOME `id` \\n ; & | * ? ! # ~",
+		"LINES":   "first\nsecond\n",
+		"UNICODE": "héllo 世界",
+		"EMPTY":   "",
+	}
+	path := filepath.Join(t.TempDir(), "exported.env")
+	var buf strings.Builder
+	if err := env.WriteDotEnv(&buf, nil); err != nil {
+		t.Fatalf("WriteDotEnv returned error: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(buf.String()), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for key, want := range env {
+		out, err := exec.Command(sh, "-c", `. "$1" && printf '%s' "$`+key+`"`, "sh", path).Output()
+		if err != nil {
+			t.Fatalf("Failed to source exported file: %v", err)
+		}
+		if string(out) != want {
+			t.Errorf("%s read back as %q, want %q", key, out, want)
+		}
+	}
+}
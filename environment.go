@@ -2,19 +2,46 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"html"
+	htmltemplate "html/template"
 	"io"
+	"maps"
+	"math"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
+	_ "time/tzdata" // embedded zone database, the scratch image has none
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
 )
 
 // Environment represents a mapping of environment variable keys to their values
@@ -25,6 +52,93 @@ func NewEnvironment(envMap map[string]string) Environment {
 	return Environment(envMap)
 }
 
+// NewEnvironmentWithProfile creates a new Environment from a map of environment variables
+// where profile-prefixed keys take precedence: with profile "PROD", a PROD_X value shadows X.
+// The prefixed keys stay available as well, an empty profile behaves like NewEnvironment.
+func NewEnvironmentWithProfile(envMap map[string]string, profile string) Environment {
+	if profile == "" {
+		return NewEnvironment(envMap)
+	}
+	return NewEnvironmentWithPrefix(envMap, profile+"_")
+}
+
+// NewEnvironmentWithPrefix creates a new Environment from a map of environment variables
+// where keys starting with prefix are also available without it: with prefix "APP_", APP_PORT is available as PORT.
+// On a collision the prefixed key wins, so APP_PORT shadows an existing PORT. A key that is just the prefix is ignored.
+// The prefixed keys stay available as well, an empty prefix behaves like NewEnvironment.
+func NewEnvironmentWithPrefix(envMap map[string]string, prefix string) Environment {
+	if prefix == "" {
+		return NewEnvironment(envMap)
+	}
+	env := make(Environment, len(envMap))
+	for k, v := range envMap {
+		env[k] = v
+	}
+	for k, v := range envMap {
+		if key, ok := strings.CutPrefix(k, prefix); ok && key != "" {
+			env[key] = v
+		}
+	}
+	return env
+}
+
+// variableReference matches $$, ${NAME} and $NAME, other $ sequences such as $5 or $? are not references
+var variableReference = regexp.MustCompile(`\$(\$|\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// Expand returns a new Environment in which ${VAR} and $VAR references inside values are replaced by the
+// value of the referenced variable, which is expanded itself first, so references may be nested. $$ yields a literal $.
+// A $ that does not start a variable name, such as in $5 or $?, is kept as it is.
+// Keys are expanded in alphabetical order, so the reported error is the same on every run.
+// A reference cycle is an error. Undefined references expand to an empty string, or are an error if strict is set.
+func (env Environment) Expand(strict bool) (Environment, error) {
+	result := make(Environment, len(env))
+	var path []string
+	var expand func(key string) error
+	expand = func(key string) error {
+		if _, ok := result[key]; ok {
+			return nil
+		}
+		if i := slices.Index(path, key); i >= 0 {
+			return fmt.Errorf("variable reference cycle: %s -> %s", strings.Join(path[i:], " -> "), key)
+		}
+		path = append(path, key)
+		defer func() { path = path[:len(path)-1] }()
+
+		var err error
+		value := variableReference.ReplaceAllStringFunc(env[key], func(ref string) string {
+			if err != nil {
+				return ""
+			}
+			if ref == "$$" {
+				return "$"
+			}
+			name := strings.Trim(ref, "${}")
+			if _, ok := env[name]; !ok {
+				if strict {
+					err = fmt.Errorf("variable '%s' references undefined variable '%s'", key, name)
+				}
+				return ""
+			}
+			if err = expand(name); err != nil {
+				return ""
+			}
+			return result[name]
+		})
+		if err != nil {
+			return err
+		}
+		result[key] = value
+		return nil
+	}
+
+	for _, key := range sortedKeys(env) {
+		if err := expand(key); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
 // AsString retrieves a string value for the given environment key
 // Panics if the key is not found
 func (env Environment) AsString(key string) string {
@@ -75,6 +189,17 @@ func (env Environment) AsStringOr(key, defaultValue string) string {
 	return value
 }
 
+// AsStringWithSource retrieves a string value for the given environment key
+// followed by a trailing comment naming the key, e.g. "value # from KEY"
+// An empty commentPrefix is left out, giving "value from KEY"
+// Panics if the key is not found
+func (env Environment) AsStringWithSource(key, commentPrefix string) string {
+	if commentPrefix == "" {
+		return env.AsString(key) + " from " + key
+	}
+	return env.AsString(key) + " " + commentPrefix + " from " + key
+}
+
 // AsStringSlice retrieves a string value for the given environment key and splits it by delimiter
 // Panics if the key is not found
 func (env Environment) AsStringSlice(key, delimiter string) []string {
@@ -101,6 +226,17 @@ func (env Environment) AsStringSliceTrim(key, delimiter string, trimChars string
 	return elements
 }
 
+// AsStringSliceN retrieves a string value for the given environment key and splits it by delimiter
+// into at most n elements, the last element holding the unsplit remainder (n == 0 returns nil)
+// Panics if the key is not found
+func (env Environment) AsStringSliceN(key, delimiter string, n int) []string {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	return strings.SplitN(value, delimiter, n)
+}
+
 // AsBool retrieves a boolean value for the given environment key
 // Accepts "true", "1", "yes" as true and "false", "0", "no" as false (case insensitive)
 // Panics if the key is not found or the value cannot be parsed as a boolean
@@ -148,17 +284,56 @@ func (env Environment) AsURL(key string) string {
 	if !ok {
 		panic(fmt.Errorf("environment variable '%s' not found", key))
 	}
-	u, err := url.ParseRequestURI(value)
-	if err != nil || u.Scheme == "" {
+	u, err := parseURL(value)
+	if err != nil {
 		panic(fmt.Errorf("could not parse '%s' (value: '%s') as URL: %v", key, value, err))
 	}
 	return u.String()
 }
 
+// parseURL parses s as an absolute URL, which requires a scheme
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		return nil, errors.New("missing scheme")
+	}
+	return u, nil
+}
+
 // AsHostPort retrieves a host:port value for the given environment key
 // Prefixes with "http://" before parsing to extract the host
 // Panics if the key is not found or the value cannot be parsed
 func (env Environment) AsHostPort(key string) string {
+	u, _ := env.parseHostPort(key)
+	return u.Host
+}
+
+// AsHostPortParts retrieves a host:port value for the given environment key and returns the host and
+// the port separately, validated like AsHostPort. Brackets around IPv6 literals are removed, so
+// "[::1]:8080" gives "::1" and 8080
+// Panics if the key is not found or the value cannot be parsed
+func (env Environment) AsHostPortParts(key string) (string, int) {
+	u, port := env.parseHostPort(key)
+	return u.Hostname(), port
+}
+
+// Host retrieves the host of a host:port value for the given environment key, see AsHostPortParts
+func (env Environment) Host(key string) string {
+	host, _ := env.AsHostPortParts(key)
+	return host
+}
+
+// Port retrieves the port of a host:port value for the given environment key, see AsHostPortParts
+func (env Environment) Port(key string) int {
+	_, port := env.AsHostPortParts(key)
+	return port
+}
+
+// parseHostPort parses the host:port value of key for AsHostPort and AsHostPortParts
+func (env Environment) parseHostPort(key string) (*url.URL, int) {
 	value, ok := env[key]
 	if !ok {
 		panic(fmt.Errorf("environment variable '%s' not found", key))
@@ -175,7 +350,57 @@ func (env Environment) AsHostPort(key string) string {
 	if port < 1 || port > 65535 {
 		panic(fmt.Errorf("port '%s' (value: '%s') is out of range (1-65535)", key, value))
 	}
-	return u.Host
+	return u, port
+}
+
+// AsIP retrieves an IPv4 or IPv6 address for the given environment key in its canonical form,
+// so "::0001" becomes "::1". IPv4-mapped IPv6 addresses such as "::ffff:1.2.3.4" count as IPv4
+// and are returned in dotted form
+// Panics if the key is not found or the value is not an IP address
+func (env Environment) AsIP(key string) string {
+	return env.asIP(key).String()
+}
+
+// AsIPv4 retrieves an IPv4 address for the given environment key, see AsIP
+// Panics if the key is not found or the value is not an IPv4 or IPv4-mapped IPv6 address
+func (env Environment) AsIPv4(key string) string {
+	ip := env.asIP(key)
+	if ip.To4() == nil {
+		panic(fmt.Errorf("'%s' (value: '%s') is not an IPv4 address", key, env[key]))
+	}
+	return ip.String()
+}
+
+// AsIPv6 retrieves an IPv6 address for the given environment key, see AsIP
+// Panics if the key is not found or the value is an IPv4 or IPv4-mapped IPv6 address
+func (env Environment) AsIPv6(key string) string {
+	ip := env.asIP(key)
+	if ip.To4() != nil {
+		panic(fmt.Errorf("'%s' (value: '%s') is not an IPv6 address", key, env[key]))
+	}
+	return ip.String()
+}
+
+// asIP parses the value of key as an IP address for AsIP, AsIPv4 and AsIPv6
+func (env Environment) asIP(key string) net.IP {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	ip, err := parseIP(value)
+	if err != nil {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as IP address", key, value))
+	}
+	return ip
+}
+
+// parseIP parses s as an IPv4 or IPv6 address
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address '%s'", s)
+	}
+	return ip, nil
 }
 
 // AsInt retrieves an integer value for the given environment key
@@ -208,6 +433,36 @@ func (env Environment) AsIntOr(key string, defaultValue int) int {
 	return intValue
 }
 
+// AsIntInRange retrieves an integer value for the given environment key that must lie within minValue and maxValue, inclusive
+// Panics if the key is not found, the value cannot be parsed as an integer or is out of range, or if minValue exceeds maxValue
+func (env Environment) AsIntInRange(key string, minValue, maxValue int) int {
+	if minValue > maxValue {
+		panic(fmt.Errorf("invalid range %d-%d for '%s'", minValue, maxValue, key))
+	}
+	intValue := env.AsInt(key)
+	if intValue < minValue || intValue > maxValue {
+		panic(fmt.Errorf("'%s' (value: '%d') is out of range (%d-%d)", key, intValue, minValue, maxValue))
+	}
+	return intValue
+}
+
+// AsIntInRangeOr retrieves an integer value for the given environment key that must lie within minValue and maxValue, inclusive
+// Returns the defaultValue if the key is not found, the value cannot be parsed, or is out of range
+// Panics if the defaultValue is out of range or if minValue exceeds maxValue
+func (env Environment) AsIntInRangeOr(key string, minValue, maxValue, defaultValue int) int {
+	if minValue > maxValue {
+		panic(fmt.Errorf("invalid range %d-%d for '%s'", minValue, maxValue, key))
+	}
+	if defaultValue < minValue || defaultValue > maxValue {
+		panic(fmt.Errorf("default value '%d' is out of range (%d-%d)", defaultValue, minValue, maxValue))
+	}
+	intValue := env.AsIntOr(key, defaultValue)
+	if intValue < minValue || intValue > maxValue {
+		return defaultValue
+	}
+	return intValue
+}
+
 // AsIntSlice retrieves a string value, splits it by delimiter, and converts each element to an integer
 // Panics if the key is not found or any element cannot be parsed as an integer
 func (env Environment) AsIntSlice(key, delimiter string) []int {
@@ -261,6 +516,21 @@ func (env Environment) AsFloatOr(key string, defaultValue float64) float64 {
 	return floatValue
 }
 
+// AsDuration retrieves a duration value such as "1m30s" for the given environment key
+// Panics if the key is not found or the value cannot be parsed as a duration
+func (env Environment) AsDuration(key string) time.Duration {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as duration: %v", key, value, err))
+	}
+	return duration
+}
+
 // AsFloatSlice retrieves a string value, splits it by delimiter, and converts each element to an integer
 // Panics if the key is not found or any element cannot be parsed as an integer
 func (env Environment) AsFloatSlice(key, delimiter string) []float64 {
@@ -284,6 +554,66 @@ func (env Environment) AsFloatSlice(key, delimiter string) []float64 {
 	return intSlice
 }
 
+// AsPercentileList retrieves a comma separated list of percentiles for the given environment key
+// Each element must be a number in the range (0, 100], the result is sorted and duplicates are removed
+// Panics if the key is not found or any element is invalid or out of range
+func (env Environment) AsPercentileList(key string) []float64 {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+
+	stringElements := strings.Split(value, ",")
+	percentiles := make([]float64, 0, len(stringElements))
+
+	for _, element := range stringElements {
+		trimmedElement := strings.TrimSpace(element)
+		floatValue, err := strconv.ParseFloat(trimmedElement, 64)
+		if err != nil {
+			panic(fmt.Errorf("on key '%s', could not parse '%s' as percentile: %v", key, trimmedElement, err))
+		}
+		if math.IsNaN(floatValue) {
+			panic(fmt.Errorf("on key '%s', percentile '%s' is not a number", key, trimmedElement))
+		}
+		if floatValue <= 0 || floatValue > 100 {
+			panic(fmt.Errorf("on key '%s', percentile '%s' is out of range (0-100]", key, trimmedElement))
+		}
+		percentiles = append(percentiles, floatValue)
+	}
+
+	slices.Sort(percentiles)
+	return slices.Compact(percentiles)
+}
+
+// AsPercent retrieves a percentage such as "75%" for the given environment key as a fraction (0.75)
+// The "%" suffix is optional, bare numbers are percentages as well, so "75" and "0.75" mean 75% and 0.75%
+// Panics if the key is not found, the value cannot be parsed or is outside the range 0-100%
+func (env Environment) AsPercent(key string) float64 {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	fraction, err := parsePercent(value)
+	if err != nil {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as percent: %v", key, value, err))
+	}
+	return fraction
+}
+
+// AsPercentOr retrieves a percentage for the given environment key as a fraction
+// Returns the defaultValue if the key is not found, the value cannot be parsed or is out of range
+func (env Environment) AsPercentOr(key string, defaultValue float64) float64 {
+	value, ok := env[key]
+	if !ok {
+		return defaultValue
+	}
+	fraction, err := parsePercent(value)
+	if err != nil {
+		return defaultValue
+	}
+	return fraction
+}
+
 // AsPort retrieves a port number for the given environment key
 // Validates that the port is in the valid range (1-65535)
 // Panics if the key is not found, the value cannot be parsed, or is outside the valid range
@@ -293,14 +623,29 @@ func (env Environment) AsPort(key string) int {
 		panic(fmt.Errorf("environment variable '%s' not found", key))
 	}
 
-	intValue, err := strconv.Atoi(value)
+	port, err := parsePort(value)
+	if errors.Is(err, errPortRange) {
+		panic(fmt.Errorf("port '%s' (value: '%s') is out of range (1-65535)", key, value))
+	}
 	if err != nil {
 		panic(fmt.Errorf("could not parse '%s' (value: '%s') as integer: %v", key, value, err))
 	}
-	if intValue < 1 || intValue > 65535 {
-		panic(fmt.Errorf("port '%s' (value: '%s') is out of range (1-65535)", key, value))
+	return port
+}
+
+// errPortRange reports a port number outside of 1-65535
+var errPortRange = errors.New("port is out of range (1-65535)")
+
+// parsePort parses s as a port number from 1 to 65535
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
 	}
-	return intValue
+	if port < 1 || port > 65535 {
+		return 0, errPortRange
+	}
+	return port, nil
 }
 
 // AsPortOr retrieves a port number for the given environment key
@@ -315,146 +660,1515 @@ func (env Environment) AsPortOr(key string, defaultPort int) int {
 		return defaultPort
 	}
 
-	intValue, err := strconv.Atoi(value)
+	port, err := parsePort(value)
 	if err != nil {
 		return defaultPort
 	}
-	if intValue < 1 || intValue > 65535 {
-		return defaultPort
-	}
-	return intValue
+	return port
 }
 
-// All returns the entire environment map
-func (env Environment) All() map[string]string {
-	return env
+// AsPath retrieves a file path for the given environment key cleaned with filepath.Clean
+// Panics if the key is not found or the value is empty
+func (env Environment) AsPath(key string) string {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	if value == "" {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as path", key, value))
+	}
+	return filepath.Clean(value)
 }
 
-// SortAll returns a new map with keys sorted alphabetically
-func (env Environment) SortAll() map[string]string {
-	keys := make([]string, 0, len(env))
-	for k := range env {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	result := make(map[string]string, len(env))
-	for _, k := range keys {
-		result[k] = env[k]
+// AsAbsPath retrieves a file path for the given environment key resolved to an absolute path
+// Relative paths are resolved against the working directory
+// Panics if the key is not found, the value is empty or cannot be resolved
+func (env Environment) AsAbsPath(key string) string {
+	path := env.AsPath(key)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		panic(fmt.Errorf("could not resolve '%s' (value: '%s') as absolute path: %v", key, path, err))
 	}
-	return result
+	return absPath
 }
 
-// isEmpty checks if a string is empty or contains only whitespace
-func isEmpty(s string) bool {
-	return strings.TrimSpace(s) == ""
+// AsExistingPath retrieves an absolute file path for the given environment key that exists on disk
+// Panics if the key is not found, the value cannot be resolved or the path does not exist
+func (env Environment) AsExistingPath(key string) string {
+	absPath := env.AsAbsPath(key)
+	if _, err := os.Stat(absPath); err != nil {
+		panic(fmt.Errorf("path '%s' (value: '%s') does not exist: %v", key, absPath, err))
+	}
+	return absPath
 }
 
-// isNotEmpty checks if a string is not empty or contains only whitespace
-func isNotEmpty(s string) bool {
-	return strings.TrimSpace(s) != ""
+// AsUUID retrieves a UUID in the canonical 8-4-4-4-12 hex form for the given environment key
+// Upper case hex digits are accepted, the result is normalized to lower case
+// Panics if the key is not found or the value is not a valid UUID
+func (env Environment) AsUUID(key string) string {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	if !isUUID(value) {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as UUID", key, value))
+	}
+	return strings.ToLower(value)
 }
 
-// contains checks if a string contains a substring
-func contains(s, substr string) bool {
-	return strings.Contains(s, substr)
+// AsUUIDOr retrieves a UUID in the canonical 8-4-4-4-12 hex form for the given environment key
+// Returns the defaultValue if the key is not found or the value is not a valid UUID
+func (env Environment) AsUUIDOr(key, defaultValue string) string {
+	value, ok := env[key]
+	if !ok || !isUUID(value) {
+		return defaultValue
+	}
+	return strings.ToLower(value)
 }
 
-// containsCaseInsensitive checks if a string contains a substring, ignoring case
-func containsCaseInsensitive(s, substr string) bool {
-	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+// AsSemver retrieves a semantic version (MAJOR.MINOR.PATCH with optional pre-release and build metadata)
+// for the given environment key. A leading "v" is accepted and removed from the result
+// Panics if the key is not found or the value is not a valid semantic version
+func (env Environment) AsSemver(key string) string {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	v, err := semver.StrictNewVersion(strings.TrimPrefix(value, "v"))
+	if err != nil {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as semantic version: %v", key, value, err))
+	}
+	return v.String()
 }
 
-// containsAny checks if a string contains any of the specified characters
-func containsAny(s, chars string) bool {
-	return strings.ContainsAny(s, chars)
+// AsColor retrieves a hex color such as "#1a2b3c" or "abc" for the given environment key
+// The leading "#" is optional, the result is normalized to lower case "#rrggbb"
+// Panics if the key is not found or the value is not a 3 or 6 digit hex color
+func (env Environment) AsColor(key string) string {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	color, ok := normalizeHexColor(value)
+	if !ok {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as hex color", key, value))
+	}
+	return color
 }
 
-// hasPrefix checks if a string starts with the specified prefix
-func hasPrefix(s, prefix string) bool {
-	return strings.HasPrefix(s, prefix)
+// AsColorOr retrieves a hex color for the given environment key normalized to lower case "#rrggbb"
+// Returns the defaultValue if the key is not found or the value is not a 3 or 6 digit hex color
+func (env Environment) AsColorOr(key, defaultValue string) string {
+	value, ok := env[key]
+	if !ok {
+		return defaultValue
+	}
+	color, ok := normalizeHexColor(value)
+	if !ok {
+		return defaultValue
+	}
+	return color
 }
 
-// hasSuffix checks if a string ends with the specified suffix
-func hasSuffix(s, suffix string) bool {
-	return strings.HasSuffix(s, suffix)
+// AsTimeZone retrieves an IANA time zone name for the given environment key
+// Returns the canonical name of the loaded location
+// Panics if the key is not found or the value is not a known time zone
+func (env Environment) AsTimeZone(key string) string {
+	value, ok := env[key]
+	if !ok {
+		panic(fmt.Errorf("environment variable '%s' not found", key))
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil || value == "" {
+		panic(fmt.Errorf("could not parse '%s' (value: '%s') as time zone: %v", key, value, err))
+	}
+	return loc.String()
 }
 
-// toLower converts a string to lowercase
-func toLower(s string) string {
-	return strings.ToLower(s)
+// AsTimeZoneOr retrieves an IANA time zone name for the given environment key
+// Returns the defaultValue if the key is not found or the value is not a known time zone
+func (env Environment) AsTimeZoneOr(key, defaultValue string) string {
+	value, ok := env[key]
+	if !ok {
+		return defaultValue
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil || value == "" {
+		return defaultValue
+	}
+	return loc.String()
 }
 
-// toUpper converts a string to uppercase
-func toUpper(s string) string {
-	return strings.ToUpper(s)
+// WithoutEmpty returns a new Environment without the keys whose value is empty or whitespace only
+func (env Environment) WithoutEmpty() Environment {
+	result := make(Environment, len(env))
+	for k, v := range env {
+		if isNotEmpty(v) {
+			result[k] = v
+		}
+	}
+	return result
 }
 
-// trim removes the specified characters from the beginning and end of a string
-func trim(s, cutset string) string {
-	return strings.Trim(s, cutset)
+// emptyValueError rewrites the error of a template that failed on a key WithoutEmpty removed from env,
+// so it says the value is empty instead of that the variable was not found
+func emptyValueError(err error, env Environment) error {
+	message := err.Error()
+	for _, key := range sortedKeys(env) {
+		if isNotEmpty(env[key]) {
+			continue
+		}
+		empty := fmt.Sprintf("environment variable '%s' is empty or whitespace only", key)
+		message = strings.ReplaceAll(message, fmt.Sprintf("environment variable '%s' not found", key), empty)
+		message = strings.ReplaceAll(message, fmt.Sprintf("map has no entry for key %q", key), empty)
+	}
+	if message == err.Error() {
+		return err
+	}
+	// keep the execution error, it carries the position of the failing node
+	var execErr template.ExecError
+	if errors.As(err, &execErr) {
+		return template.ExecError{Name: execErr.Name, Err: errors.New(message)}
+	}
+	return errors.New(message)
 }
 
-// trimLeft removes the specified characters from the beginning of a string
-func trimLeft(s, cutset string) string {
-	return strings.TrimLeft(s, cutset)
+// AsStringOrFile retrieves a string value for the given environment key, where a value of @path
+// is replaced by the contents of the file at path. A literal leading @ is written as @@
+// Panics if the key is not found or the referenced file cannot be read
+func (env Environment) AsStringOrFile(key string) string {
+	value := env.AsString(key)
+	if strings.HasPrefix(value, "@@") {
+		return value[1:]
+	}
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		return readFile(path)
+	}
+	return value
 }
 
-// trimRight removes the specified characters from the end of a string
-func trimRight(s, cutset string) string {
-	return strings.TrimRight(s, cutset)
+// ReadSecret returns the contents of a Docker/Kubernetes secret file with a single trailing newline removed
+// Secrets are read from /run/secrets/<name> unless ZEP_SECRETS_DIR points to another directory
+// Panics if the name is not a plain file name or the secret cannot be read
+func (env Environment) ReadSecret(name string) string {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		panic(fmt.Errorf("invalid secret name '%s'", name))
+	}
+	dir := env.AsStringOr("ZEP_SECRETS_DIR", "/run/secrets")
+	content := readFile(filepath.Join(dir, name))
+	if trimmed, ok := strings.CutSuffix(content, "\n"); ok {
+		return strings.TrimSuffix(trimmed, "\r")
+	}
+	return content
 }
 
-// trimSpace removes whitespace from the beginning and end of a string
-func trimSpace(s string) string {
-	return strings.TrimSpace(s)
+// All returns the entire environment map
+func (env Environment) All() map[string]string {
+	return env
 }
 
-// base64Encode encodes a string to base64
-func base64Encode(s string) string {
-	return base64.StdEncoding.EncodeToString([]byte(s))
+// Map returns a copy of the environment, templates read it with envMap, for example {{ (envMap).PORT }}
+func (env Environment) Map() map[string]string {
+	return maps.Clone(map[string]string(env))
 }
 
-// base64Decode decodes a base64 string
-// Panics if the string cannot be decoded
+// SortAll returns a new map with keys sorted alphabetically
+func (env Environment) SortAll() map[string]string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make(map[string]string, len(env))
+	for _, k := range keys {
+		result[k] = env[k]
+	}
+	return result
+}
+
+// Hash returns a fingerprint of the whole environment computed with one of the algorithms of hash.
+// Keys and values are quoted and hashed as sorted key=value lines, so the result only depends on the
+// content of the environment and no two different environments produce the same input
+// Panics if an unsupported algorithm is specified
+func (env Environment) Hash(algorithm string) string {
+	var sb strings.Builder
+	for _, k := range sortedKeys(env) {
+		sb.WriteString(strconv.Quote(k) + "=" + strconv.Quote(env[k]) + "\n")
+	}
+	return hash(sb.String(), algorithm)
+}
+
+// SortAllPrefix returns a new map with the variables whose keys start with prefix, sorted alphabetically
+// The keys are kept intact, including the prefix
+func (env Environment) SortAllPrefix(prefix string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range env.SortAll() {
+		if strings.HasPrefix(k, prefix) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// semverCompare checks if a version satisfies a constraint such as ">=1.4.0" or "~1.2"
+// Versions with a leading "v" are accepted
+// Panics if the constraint or the version cannot be parsed
+func semverCompare(constraint, version string) bool {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		panic(fmt.Errorf("could not parse semver constraint '%s': %v", constraint, err))
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		panic(fmt.Errorf("could not parse semantic version '%s': %v", version, err))
+	}
+	return c.Check(v)
+}
+
+// isUUID checks if a string is a UUID in the canonical 8-4-4-4-12 hex form
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			c := s[i]
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parsePercent converts a percentage with optional "%" suffix to a fraction in the range [0, 1]
+func parsePercent(s string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(percent) {
+		return 0, fmt.Errorf("not a number")
+	}
+	if percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("out of range (0-100%%)")
+	}
+	return percent / 100, nil
+}
+
+// normalizeHexColor converts a 3 or 6 digit hex color with optional "#" to lower case "#rrggbb"
+func normalizeHexColor(s string) (string, bool) {
+	digits := strings.ToLower(strings.TrimPrefix(s, "#"))
+	if len(digits) != 3 && len(digits) != 6 {
+		return "", false
+	}
+	for _, c := range digits {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return "", false
+		}
+	}
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+	return "#" + digits, true
+}
+
+// isEmpty checks if a string is empty or contains only whitespace
+func isEmpty(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+// isNotEmpty checks if a string is not empty or contains only whitespace
+func isNotEmpty(s string) bool {
+	return strings.TrimSpace(s) != ""
+}
+
+// isInt reports whether s can be read with asInt
+func isInt(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// isFloat reports whether s can be read with asFloat
+func isFloat(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// isURL reports whether s can be read with asURL, which requires a scheme
+func isURL(s string) bool {
+	_, err := parseURL(s)
+	return err == nil
+}
+
+// isPort reports whether s can be read with asPort, an integer from 1 to 65535
+func isPort(s string) bool {
+	_, err := parsePort(s)
+	return err == nil
+}
+
+// isIP reports whether s can be read with asIP, an IPv4 or IPv6 address
+func isIP(s string) bool {
+	_, err := parseIP(s)
+	return err == nil
+}
+
+// contains checks if a string contains a substring
+func contains(s, substr string) bool {
+	return strings.Contains(s, substr)
+}
+
+// containsCaseInsensitive checks if a string contains a substring, ignoring case
+func containsCaseInsensitive(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// containsAny checks if a string contains any of the specified characters
+func containsAny(s, chars string) bool {
+	return strings.ContainsAny(s, chars)
+}
+
+// hasPrefix checks if a string starts with the specified prefix
+func hasPrefix(s, prefix string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// hasSuffix checks if a string ends with the specified suffix
+func hasSuffix(s, suffix string) bool {
+	return strings.HasSuffix(s, suffix)
+}
+
+// toLower converts a string to lowercase
+func toLower(s string) string {
+	return strings.ToLower(s)
+}
+
+// toUpper converts a string to uppercase
+func toUpper(s string) string {
+	return strings.ToUpper(s)
+}
+
+// title converts the first rune of each word to title case with unicode.ToTitle, which differs from
+// upper case for digraphs such as ǆ, giving ǅ rather than Ǆ
+// Words are separated by Unicode white space, the remaining runes are left untouched
+func title(s string) string {
+	runes := []rune(s)
+	startOfWord := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			startOfWord = true
+			continue
+		}
+		if startOfWord {
+			runes[i] = unicode.ToTitle(r)
+			startOfWord = false
+		}
+	}
+	return string(runes)
+}
+
+// capitalize converts only the first rune of a string to title case, see title
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToTitle(r)) + s[size:]
+}
+
+// repeat returns a string consisting of count copies of s
+// Panics if count is negative
+func repeat(count int, s string) string {
+	if count < 0 {
+		panic(fmt.Errorf("repeat count '%d' must not be negative", count))
+	}
+	return strings.Repeat(s, count)
+}
+
+// padding builds a pad string of exactly width runes by repeating pad
+func padding(width int, pad string) string {
+	padRunes := []rune(pad)
+	if width <= 0 || len(padRunes) == 0 {
+		return ""
+	}
+	result := make([]rune, width)
+	for i := range result {
+		result[i] = padRunes[i%len(padRunes)]
+	}
+	return string(result)
+}
+
+// padLeft pads the beginning of a string with pad until it is width runes long
+// Returns the string unchanged if it is already at or over the target width
+func padLeft(width int, pad, s string) string {
+	return padding(width-utf8.RuneCountInString(s), pad) + s
+}
+
+// padRight pads the end of a string with pad until it is width runes long
+// Returns the string unchanged if it is already at or over the target width
+func padRight(width int, pad, s string) string {
+	return s + padding(width-utf8.RuneCountInString(s), pad)
+}
+
+// padNum formats an integer zero-padded to width digits, the sign of negative numbers counts towards the width
+func padNum(width, n int) string {
+	return fmt.Sprintf("%0*d", width, n)
+}
+
+// format formats the arguments according to a printf-style format specifier
+func format(format string, args ...any) string {
+	return fmt.Sprintf(format, args...)
+}
+
+// strictArg stands in for an argument of sprintfStrict to check the verb it is formatted with
+// It writes nothing and records the first verb that does not match the type of its value
+type strictArg struct {
+	value    any
+	mismatch *error
+}
+
+func (a strictArg) Format(f fmt.State, verb rune) {
+	out := fmt.Sprintf(fmt.FormatString(f, verb), a.value)
+	if *a.mismatch == nil && strings.Contains(out, "%!"+string(verb)+"(") {
+		*a.mismatch = fmt.Errorf("verb '%%%c' does not match argument '%v' of type %T", verb, a.value, a.value)
+	}
+}
+
+// isPointerLike reports whether a value can be formatted with '%p' without being a slice or a map
+func isPointerLike(v any) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Chan, reflect.Func:
+		return true
+	}
+	return false
+}
+
+// sprintfStrict formats the arguments like printf, but instead of emitting %!d(string=...) markers
+// it fails when a verb does not match the type of its argument or the number of arguments is wrong
+// Panics if the format does not match the arguments
+func sprintfStrict(format string, args ...any) string {
+	var mismatch error
+	probeArgs := make([]any, len(args))
+	for i, arg := range args {
+		probeArgs[i] = strictArg{value: arg, mismatch: &mismatch}
+		// '*' widths and precisions must be ints and '%p' is resolved before Format is called,
+		// so these are passed as they are and a mismatch shows up as a marker in the probe
+		if _, ok := arg.(int); ok || isPointerLike(arg) {
+			probeArgs[i] = arg
+		}
+	}
+	// the probe only contains the format text and the markers of missing or extra arguments
+	probe := fmt.Sprintf(format, probeArgs...)
+	if mismatch != nil {
+		panic(fmt.Errorf("could not format '%s': %v", format, mismatch))
+	}
+	if strings.Count(probe, "%!") > strings.Count(format, "%%!") {
+		panic(fmt.Errorf("could not format '%s' with %d arguments: %s", format, len(args), probe))
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// nospace removes every Unicode whitespace rune from a string, not only at the ends like trimSpace
+func nospace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// truncBytes returns the longest prefix of a string that is at most n bytes long without splitting a rune
+func truncBytes(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// reverse reverses a string rune by rune, so multibyte characters stay intact
+func reverse(s string) string {
+	runes := []rune(s)
+	slices.Reverse(runes)
+	return string(runes)
+}
+
+// wrap word-wraps a string at width runes, see wrapWith
+func wrap(width int, s string) string {
+	return wrapWith(width, "\n", s)
+}
+
+// wrapWith word-wraps a string at width runes and joins the resulting lines with sep
+// Existing line breaks, and so paragraph breaks, are kept and also joined with sep
+// Whitespace between words is collapsed; words longer than width are put on a line of their own, not broken
+func wrapWith(width int, sep, s string) string {
+	var result []string
+	for _, line := range strings.Split(s, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			result = append(result, "")
+			continue
+		}
+		current := words[0]
+		currentWidth := utf8.RuneCountInString(current)
+		for _, word := range words[1:] {
+			wordWidth := utf8.RuneCountInString(word)
+			if currentWidth+1+wordWidth > width {
+				result = append(result, current)
+				current, currentWidth = word, wordWidth
+				continue
+			}
+			current += " " + word
+			currentWidth += 1 + wordWidth
+		}
+		result = append(result, current)
+	}
+	return strings.Join(result, sep)
+}
+
+// substr returns the runes of s between start (inclusive) and end (exclusive)
+// Out of range indices are clamped, a negative end means the end of the string
+func substr(start, end int, s string) string {
+	runes := []rune(s)
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 || end > len(runes) {
+		end = len(runes)
+	}
+	if start >= end {
+		return ""
+	}
+	return string(runes[start:end])
+}
+
+// trunc returns the first n runes of s, or the last |n| runes if n is negative
+// Returns the string unchanged if it is shorter than |n|
+func trunc(n int, s string) string {
+	runes := []rune(s)
+	if n < 0 {
+		if -n >= len(runes) {
+			return s
+		}
+		return string(runes[len(runes)+n:])
+	}
+	if n >= len(runes) {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// trim removes the specified characters from the beginning and end of a string
+func trim(s, cutset string) string {
+	return strings.Trim(s, cutset)
+}
+
+// trimLeft removes the specified characters from the beginning of a string
+func trimLeft(s, cutset string) string {
+	return strings.TrimLeft(s, cutset)
+}
+
+// trimRight removes the specified characters from the end of a string
+func trimRight(s, cutset string) string {
+	return strings.TrimRight(s, cutset)
+}
+
+// trimSpace removes whitespace from the beginning and end of a string
+func trimSpace(s string) string {
+	return strings.TrimSpace(s)
+}
+
+// trimPrefix removes the exact prefix from the beginning of a string if present
+// The string is the last argument so it can be used in pipelines
+func trimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// trimSuffix removes the exact suffix from the end of a string if present
+// The string is the last argument so it can be used in pipelines
+func trimSuffix(suffix, s string) string {
+	return strings.TrimSuffix(s, suffix)
+}
+
+// quote wraps a string in double quotes using Go escaping rules
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+// squote wraps a string in single quotes, embedded single quotes are doubled as in YAML
+func squote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// shellQuote wraps a string in single quotes for safe use as a POSIX shell word
+// Embedded single quotes end the quoted word, are backslash escaped and start a new quoted word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// htmlEscape escapes the special HTML characters <, >, &, ' and "
+func htmlEscape(s string) string {
+	return html.EscapeString(s)
+}
+
+// htmlUnescape converts HTML entities such as &lt; back to their characters
+func htmlUnescape(s string) string {
+	return html.UnescapeString(s)
+}
+
+// mask redacts a secret for diagnostics by replacing all but the first and last 2 runes with asterisks
+// Strings of up to 4 runes would be fully revealed, so they are masked completely
+func mask(s string) string {
+	runes := []rune(s)
+	if len(runes) <= 4 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:2]) + strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-2:])
+}
+
+// regexCache holds the compiled regular expressions of the regex functions keyed by pattern
+var regexCache sync.Map
+
+// compileRegex returns the compiled regular expression for pattern, reusing a cached one if available
+// Panics if the pattern cannot be compiled
+func compileRegex(pattern string) *regexp.Regexp {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(fmt.Errorf("could not compile regex '%s': %v", pattern, err))
+	}
+	actual, _ := regexCache.LoadOrStore(pattern, re)
+	return actual.(*regexp.Regexp)
+}
+
+// regexMatch reports whether the string contains a match of the regular expression pattern
+// Panics if the pattern cannot be compiled
+func regexMatch(pattern, s string) bool {
+	return compileRegex(pattern).MatchString(s)
+}
+
+// regexReplace replaces all matches of the regular expression pattern in the string with replacement
+// The replacement may refer to submatches with $1 or ${name}
+// Panics if the pattern cannot be compiled
+func regexReplace(pattern, replacement, s string) string {
+	return compileRegex(pattern).ReplaceAllString(s, replacement)
+}
+
+// base64Encode encodes a string to base64
+func base64Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// base64Decode decodes a base64 string
+// Panics if the string cannot be decoded
 func base64Decode(s string) string {
 	decoded, err := base64.StdEncoding.DecodeString(s)
 	if err != nil {
-		panic(fmt.Errorf("could not decode base64 string: %v", err))
+		panic(fmt.Errorf("could not decode base64 string: %v", err))
+	}
+	return string(decoded)
+}
+
+// base64URLEncode encodes a string to unpadded URL-safe base64 as used by JWTs, with - and _ in place of + and /
+func base64URLEncode(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// base64URLDecode decodes an unpadded URL-safe base64 string
+// Panics if the string cannot be decoded
+func base64URLDecode(s string) string {
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		panic(fmt.Errorf("could not decode URL-safe base64 string: %v", err))
+	}
+	return string(decoded)
+}
+
+// base32Encode encodes a string to base32 using the standard alphabet with = padding
+func base32Encode(s string) string {
+	return base32.StdEncoding.EncodeToString([]byte(s))
+}
+
+// base32Decode decodes a base32 string using the standard alphabet
+// Padding is optional so unpadded values such as TOTP secrets are accepted
+// Panics if the string cannot be decoded
+func base32Decode(s string) string {
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		panic(fmt.Errorf("could not decode base32 string: %v", err))
+	}
+	return string(decoded)
+}
+
+// gzipEncode compresses a string with gzip and returns the result base64 encoded
+func gzipEncode(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		panic(fmt.Errorf("could not gzip string: %v", err))
+	}
+	if err := w.Close(); err != nil {
+		panic(fmt.Errorf("could not gzip string: %v", err))
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// gzipDecode base64 decodes a string and decompresses the gzip data
+// Panics if the string is not valid base64 or gzip data
+func gzipDecode(s string) string {
+	compressed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(fmt.Errorf("could not decode base64 string: %v", err))
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		panic(fmt.Errorf("could not gunzip string: %v", err))
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		panic(fmt.Errorf("could not gunzip string: %v", err))
+	}
+	return string(data)
+}
+
+// hash computes a hash of the input string using the specified algorithm
+// Supported algorithms: md5, sha1, sha224, sha256, sha512
+// Panics if an unsupported algorithm is specified
+func hash(input string, algorithm string) string {
+	switch strings.ToLower(algorithm) {
+	case "md5":
+		return fmt.Sprintf("%x", md5.Sum([]byte(input)))
+	case "sha1":
+		return fmt.Sprintf("%x", sha1.Sum([]byte(input)))
+	case "sha224":
+		return fmt.Sprintf("%x", sha256.Sum224([]byte(input)))
+	case "sha256":
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(input)))
+	case "sha512":
+		return fmt.Sprintf("%x", sha512.Sum512([]byte(input)))
+	default:
+		panic(fmt.Errorf("unsupported hash algorithm: %s", algorithm))
+	}
+}
+
+// pickSeeded deterministically selects one of the options for a seed such as a hostname, the same seed
+// and options always give the same choice and different seeds are spread evenly over the options
+// Panics if there are no options
+func pickSeeded(seed string, options []string) string {
+	if len(options) == 0 {
+		panic(fmt.Errorf("pickSeeded requires at least one option"))
+	}
+	sum := sha256.Sum256([]byte(seed))
+	return options[binary.BigEndian.Uint64(sum[:8])%uint64(len(options))]
+}
+
+// bcryptHash generates a bcrypt hash of the password with the given cost, e.g. for htpasswd files
+// The salt is random, so every render produces a different hash for the same password
+// Panics if the cost is outside the supported range or the password is longer than 72 bytes
+func bcryptHash(cost int, password string) string {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		panic(fmt.Errorf("bcrypt cost '%d' is out of range (%d-%d)", cost, bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		panic(fmt.Errorf("could not generate bcrypt hash: %v", err))
+	}
+	return string(hashed)
+}
+
+// crc32Checksum computes the IEEE CRC-32 checksum of the input string as 8 lowercase hex characters
+// It is not cryptographically secure, use hash for integrity against tampering
+func crc32Checksum(input string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(input)))
+}
+
+// sequence generates a slice of integers from start to end (inclusive)
+// Returns nil if start > end
+func sequence(start, end int) []int {
+	if start > end {
+		return nil
+	}
+	seq := make([]int, end-start+1)
+	for i := start; i <= end; i++ {
+		seq[i-start] = i
+	}
+	return seq
+}
+
+// backoffSeq generates count delays of an exponential backoff, base multiplied by factor for each attempt,
+// so backoffSeq 0.5 2 4 gives 0.5, 1, 2 and 4
+// Panics if count is not positive
+func backoffSeq(base, factor float64, count int) []float64 {
+	if count <= 0 {
+		panic(fmt.Errorf("backoff count must be positive, got %d", count))
+	}
+	seq := make([]float64, count)
+	delay := base
+	for i := range seq {
+		seq[i] = delay
+		delay *= factor
+	}
+	return seq
+}
+
+// isEmptyValue reports whether a value is empty: nil, the zero value of its type, a string that
+// is empty or only whitespace like isEmpty, or a slice, array or map with no elements
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.String:
+		return isEmpty(value.String())
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return value.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	default:
+		return value.IsZero()
+	}
+}
+
+// coalesce returns the first argument that is not empty as defined by isEmptyValue
+// Returns nil if all arguments are empty
+func coalesce(values ...any) any {
+	for _, v := range values {
+		if !isEmptyValue(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// empty reports whether a value is empty as defined by isEmptyValue
+func empty(v any) bool {
+	return isEmptyValue(v)
+}
+
+// ternary returns trueValue if condition is true and falseValue otherwise
+// The condition is the last argument so it can be used in pipelines
+func ternary(trueValue, falseValue any, condition bool) any {
+	if condition {
+		return trueValue
+	}
+	return falseValue
+}
+
+// compact removes the empty and whitespace only elements from a slice
+func compact(s []string) []string {
+	result := make([]string, 0, len(s))
+	for _, element := range s {
+		if isNotEmpty(element) {
+			result = append(result, element)
+		}
+	}
+	return result
+}
+
+// lines splits a string into lines on \n or \r\n
+// A trailing newline does not produce an empty final element and an empty string has no lines
+func lines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	result := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, line := range result {
+		result[i] = strings.TrimSuffix(line, "\r")
+	}
+	return result
+}
+
+// unlines joins lines with \n, without adding a trailing newline
+func unlines(elems []string) string {
+	return strings.Join(elems, "\n")
+}
+
+// toString converts a value to its string representation
+func toString(v any) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toInt coerces a string or number to an integer, surrounding whitespace of strings is ignored
+// Panics if the value is of any other kind, a string is not an integer or a float has a fractional part
+func toInt(v any) int {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f != math.Trunc(f) {
+			panic(fmt.Errorf("could not convert '%v' to integer: fractional part", v))
+		}
+		return int(f)
+	case reflect.String:
+		intValue, err := strconv.Atoi(strings.TrimSpace(value.String()))
+		if err != nil {
+			panic(fmt.Errorf("could not convert '%v' to integer: %v", v, err))
+		}
+		return intValue
+	default:
+		panic(fmt.Errorf("toInt does not support values of type %T", v))
+	}
+}
+
+// toFloat coerces a string or number to a float, surrounding whitespace of strings is ignored
+// Panics if the value is of any other kind or a string is not a number
+func toFloat(v any) float64 {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.String:
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(value.String()), 64)
+		if err != nil {
+			panic(fmt.Errorf("could not convert '%v' to float: %v", v, err))
+		}
+		return floatValue
+	default:
+		panic(fmt.Errorf("toFloat does not support values of type %T", v))
+	}
+}
+
+// toBool coerces a string, number or boolean to a boolean
+// Strings accept the same values as AsBool, surrounding whitespace is ignored; numbers are true unless zero
+// Panics if the value is of any other kind or a string cannot be parsed as a boolean
+func toBool(v any) bool {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() != 0
+	case reflect.String:
+		switch strings.ToLower(strings.TrimSpace(value.String())) {
+		case "true", "1", "yes", "on", "enable", "enabled":
+			return true
+		case "false", "0", "no", "off", "disable", "disabled":
+			return false
+		default:
+			panic(fmt.Errorf("could not convert '%v' to boolean", v))
+		}
+	default:
+		panic(fmt.Errorf("toBool does not support values of type %T", v))
+	}
+}
+
+// abs returns the absolute value of a float
+func abs(f float64) float64 {
+	return math.Abs(f)
+}
+
+// round returns the nearest integer, rounding half away from zero so 2.5 becomes 3 and -2.5 becomes -3
+func round(f float64) int {
+	return int(math.Round(f))
+}
+
+// ceil returns the least integer greater than or equal to a float
+func ceil(f float64) int {
+	return int(math.Ceil(f))
+}
+
+// floor returns the greatest integer less than or equal to a float
+func floor(f float64) int {
+	return int(math.Floor(f))
+}
+
+// zip pairs keys and values positionally into a map, a repeated key keeps its last value
+// Panics if the slices differ in length
+func zip(keys, values []string) map[string]string {
+	if len(keys) != len(values) {
+		panic(fmt.Errorf("zip requires slices of equal length, got %d keys and %d values", len(keys), len(values)))
+	}
+	result := make(map[string]string, len(keys))
+	for i, key := range keys {
+		result[key] = values[i]
+	}
+	return result
+}
+
+// chunk splits a slice into consecutive sub-slices of size elements, the last one may be shorter
+// Panics if size is not positive
+func chunk(size int, s []string) [][]string {
+	if size <= 0 {
+		panic(fmt.Errorf("chunk size must be positive, got %d", size))
+	}
+	result := make([][]string, 0, (len(s)+size-1)/size)
+	for c := range slices.Chunk(s, size) {
+		result = append(result, c)
+	}
+	return result
+}
+
+// reverseList returns a reversed copy of a slice, the input is left unchanged
+func reverseList(s []string) []string {
+	result := slices.Clone(s)
+	slices.Reverse(result)
+	return result
+}
+
+// inSlice reports whether the item is an element of the slice
+func inSlice(item string, s []string) bool {
+	return slices.Contains(s, item)
+}
+
+// indexOf returns the index of the first occurrence of the item in the slice, or -1 if it is not present
+func indexOf(item string, s []string) int {
+	return slices.Index(s, item)
+}
+
+// count returns the number of runes in a string or the number of elements in a slice, array or map
+// Panics if the value is of any other kind
+func count(v any) int {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len()
+	default:
+		panic(fmt.Errorf("count does not support values of type %T", v))
+	}
+}
+
+// topoSort orders the nodes of a dependency map so every node comes after the nodes it depends on
+// Each key depends on its values, independent nodes are ordered alphabetically for a stable result
+// Panics with the cycle path if the graph contains a cycle
+func topoSort(edges map[string][]string) []string {
+	nodes := make(map[string]struct{}, len(edges))
+	for node, dependencies := range edges {
+		nodes[node] = struct{}{}
+		for _, dependency := range dependencies {
+			nodes[dependency] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(nodes))
+	for node := range nodes {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(nodes))
+	result := make([]string, 0, len(nodes))
+	var path []string
+
+	var visit func(node string)
+	visit = func(node string) {
+		switch state[node] {
+		case visited:
+			return
+		case visiting:
+			start := slices.Index(path, node)
+			cycle := append(slices.Clone(path[start:]), node)
+			panic(fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> ")))
+		}
+		state[node] = visiting
+		path = append(path, node)
+		dependencies := slices.Clone(edges[node])
+		sort.Strings(dependencies)
+		for _, dependency := range dependencies {
+			visit(dependency)
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		result = append(result, node)
+	}
+
+	for _, node := range names {
+		visit(node)
+	}
+	return result
+}
+
+// uniqCaseInsensitive removes case-insensitive duplicates from a slice
+// Keeps the casing of the first occurrence and preserves the original order
+func uniqCaseInsensitive(s []string) []string {
+	seen := make(map[string]struct{}, len(s))
+	result := make([]string, 0, len(s))
+	for _, element := range s {
+		lowerElement := strings.ToLower(element)
+		if _, ok := seen[lowerElement]; ok {
+			continue
+		}
+		seen[lowerElement] = struct{}{}
+		result = append(result, element)
+	}
+	return result
+}
+
+// dict builds a map from alternating key and value arguments
+// Panics if the number of arguments is odd or a key is not a string
+func dict(pairs ...any) map[string]any {
+	if len(pairs)%2 != 0 {
+		panic(fmt.Errorf("dict requires an even number of arguments, got %d", len(pairs)))
+	}
+	result := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			panic(fmt.Errorf("dict key '%v' must be a string", pairs[i]))
+		}
+		result[key] = pairs[i+1]
+	}
+	return result
+}
+
+// merge returns a new map with the entries of dst and src, where the values of src win
+// The merge is shallow, nested maps of src replace those of dst. The inputs are not modified
+func merge(dst, src map[string]any) map[string]any {
+	result := make(map[string]any, len(dst)+len(src))
+	for k, v := range dst {
+		result[k] = v
+	}
+	for k, v := range src {
+		result[k] = v
+	}
+	return result
+}
+
+// mergeDeep returns a new map with the entries of dst and src, where the values of src win
+// Nested maps present in both are merged recursively instead of replaced. The inputs are not modified
+func mergeDeep(dst, src map[string]any) map[string]any {
+	result := make(map[string]any, len(dst)+len(src))
+	for k, v := range dst {
+		result[k] = v
+	}
+	for k, v := range src {
+		dstMap, dstOk := result[k].(map[string]any)
+		srcMap, srcOk := v.(map[string]any)
+		if dstOk && srcOk {
+			result[k] = mergeDeep(dstMap, srcMap)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// hasKey checks if a string map contains the key
+func hasKey(m map[string]string, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// hasKeyAny checks if a map built with dict or parsed from structured data contains the key
+func hasKeyAny(m map[string]any, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// toJson serializes a value as compact JSON
+// Panics if the value cannot be serialized
+func toJson(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("could not serialize value as json: %v", err))
 	}
-	return string(decoded)
+	return string(data)
 }
 
-// hash computes a hash of the input string using the specified algorithm
-// Supported algorithms: md5, sha1, sha224, sha256, sha512
-// Panics if an unsupported algorithm is specified
-func hash(input string, algorithm string) string {
-	switch strings.ToLower(algorithm) {
-	case "md5":
-		return fmt.Sprintf("%x", md5.Sum([]byte(input)))
-	case "sha1":
-		return fmt.Sprintf("%x", sha1.Sum([]byte(input)))
-	case "sha224":
-		return fmt.Sprintf("%x", sha256.Sum224([]byte(input)))
-	case "sha256":
-		return fmt.Sprintf("%x", sha256.Sum256([]byte(input)))
-	case "sha512":
-		return fmt.Sprintf("%x", sha512.Sum512([]byte(input)))
-	default:
-		panic(fmt.Errorf("unsupported hash algorithm: %s", algorithm))
+// toToml serializes a map as a TOML document. Keys are sorted, plain values come first and nested maps
+// follow as [tables], so the output is the same on every run. A TOML document is always a table, so
+// slices and scalars can only be serialized as values inside a map
+// Panics if the value is not a map or cannot be serialized
+func toToml(v any) string {
+	if reflect.ValueOf(v).Kind() != reflect.Map {
+		panic(fmt.Errorf("toToml requires a map, got %T", v))
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		panic(fmt.Errorf("could not serialize value as toml: %v", err))
 	}
+	return buf.String()
 }
 
-// sequence generates a slice of integers from start to end (inclusive)
-// Returns nil if start > end
-func sequence(start, end int) []int {
-	if start > end {
-		return nil
+// fromJson parses a JSON string into a generic value that can be ranged over in templates
+// Panics if the string is not valid JSON
+func fromJson(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(fmt.Errorf("could not parse json: %v", err))
 	}
-	seq := make([]int, end-start+1)
-	for i := start; i <= end; i++ {
-		seq[i-start] = i
+	return v
+}
+
+// dig navigates a dotted path such as "database.replicas.0" through nested maps and slices
+// Map segments are keys, slice segments must be indexes; it returns nil if any segment is missing,
+// including indexes that are negative, out of range or not numeric. An empty path returns the value itself
+func dig(path string, v any) any {
+	if path == "" {
+		return v
 	}
-	return seq
+	for _, segment := range strings.Split(path, ".") {
+		value := reflect.ValueOf(v)
+		switch value.Kind() {
+		case reflect.Map:
+			if value.Type().Key().Kind() != reflect.String {
+				return nil
+			}
+			element := value.MapIndex(reflect.ValueOf(segment).Convert(value.Type().Key()))
+			if !element.IsValid() {
+				return nil
+			}
+			v = element.Interface()
+		case reflect.Slice, reflect.Array:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= value.Len() {
+				return nil
+			}
+			v = value.Index(index).Interface()
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// bundle serializes a value as JSON, YAML and TOML, each section starting with a "--- format ---" marker line
+// The value must be a map so it can be represented as a TOML table
+// Panics if the value cannot be serialized in any of the formats
+func bundle(v any) string {
+	if reflect.ValueOf(v).Kind() != reflect.Map {
+		panic(fmt.Errorf("bundle requires a map, got %T", v))
+	}
+	jsonData, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		panic(fmt.Errorf("could not serialize bundle as json: %v", err))
+	}
+	yamlData, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("could not serialize bundle as yaml: %v", err))
+	}
+	var tomlData bytes.Buffer
+	if err := toml.NewEncoder(&tomlData).Encode(v); err != nil {
+		panic(fmt.Errorf("could not serialize bundle as toml: %v", err))
+	}
+
+	var sb strings.Builder
+	for _, section := range []struct {
+		format string
+		data   string
+	}{
+		{format: "json", data: string(jsonData)},
+		{format: "yaml", data: string(yamlData)},
+		{format: "toml", data: tomlData.String()},
+	} {
+		fmt.Fprintf(&sb, "--- %s ---\n%s\n", section.format, strings.TrimRight(section.data, "\n"))
+	}
+	return sb.String()
+}
+
+// sortedKeys returns the keys of a map sorted alphabetically
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envVarName matches portable environment variable names: letters, digits and underscores, not starting with a digit
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// toPowerShellEnv renders a map as PowerShell `$env:KEY = "value"` assignments sorted by key
+// Backticks, dollar signs and the typographic double quotes “ ” „, which PowerShell also accepts as
+// string delimiters, are escaped with a backtick and double quotes are doubled
+// Panics if a key is not made of letters, digits and underscores or starts with a digit
+func toPowerShellEnv(m map[string]string) string {
+	replacer := strings.NewReplacer("`", "``", "$", "`$", `"`, `""`, "\u201c", "`\u201c", "\u201d", "`\u201d", "\u201e", "`\u201e")
+	var sb strings.Builder
+	for _, k := range sortedKeys(m) {
+		if !envVarName.MatchString(k) {
+			panic(fmt.Errorf("could not write PowerShell variable '%s', it is not a valid variable name", k))
+		}
+		fmt.Fprintf(&sb, "$env:%s = \"%s\"\n", k, replacer.Replace(m[k]))
+	}
+	return sb.String()
+}
+
+// toBatchEnv renders a map as Windows batch `set "KEY=value"` lines sorted by key
+// Inside the quotes ^ & | < > are literal, so only percent signs need escaping and are doubled.
+// An exclamation mark stays literal only while delayed expansion is disabled, the default
+// Panics if a key is not made of letters, digits and underscores or starts with a digit, or if a value
+// contains a double quote, which would end the quoting, or a line break, which would start a new command
+func toBatchEnv(m map[string]string) string {
+	var sb strings.Builder
+	for _, k := range sortedKeys(m) {
+		if !envVarName.MatchString(k) {
+			panic(fmt.Errorf("could not write batch variable '%s', it is not a valid variable name", k))
+		}
+		if strings.ContainsAny(m[k], "\"\r\n") {
+			panic(fmt.Errorf("could not write batch variable '%s', its value contains a double quote or a line break", k))
+		}
+		fmt.Fprintf(&sb, "set \"%s=%s\"\n", k, strings.ReplaceAll(m[k], "%", "%%"))
+	}
+	return sb.String()
+}
+
+// numCPU returns the number of logical CPUs usable by the process
+func numCPU() int {
+	return runtime.NumCPU()
+}
+
+// meminfoPath is the Linux file totalMemBytes reads, tests point it to a fixture
+var meminfoPath = "/proc/meminfo"
+
+// totalMemBytes returns the total physical memory in bytes as reported by the MemTotal line of /proc/meminfo.
+// It is only supported on Linux, elsewhere or if the file cannot be read or parsed it returns 0, so templates
+// can fall back to a default. Container memory limits are not taken into account
+func totalMemBytes() int64 {
+	data, err := os.ReadFile(meminfoPath)
+	if err != nil {
+		return 0
+	}
+	for line := range strings.Lines(string(data)) {
+		value, ok := strings.CutPrefix(line, "MemTotal:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 2 || fields[1] != "kB" {
+			return 0
+		}
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
+
+// hostname returns the host name reported by the kernel
+// Panics if the host name cannot be determined
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		panic(fmt.Errorf("could not determine hostname: %v", err))
+	}
+	return name
+}
+
+// fqdnTimeout limits the lookups fqdn makes, so an unreachable DNS server does not stall rendering
+const fqdnTimeout = 2 * time.Second
+
+// hostResolver is the subset of net.Resolver fqdn uses
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// fqdnResolver is the resolver fqdn looks the host up with, tests replace it to avoid DNS queries
+var fqdnResolver hostResolver = net.DefaultResolver
+
+// fqdn returns the fully qualified domain name of the host, see lookupFQDN
+// Panics if the host name cannot be determined
+func fqdn() string {
+	return lookupFQDN(hostname())
+}
+
+// lookupFQDN returns the fully qualified domain name for a host name. A name that already contains a dot is
+// returned as is, otherwise the first name with a dot found by a reverse lookup of the host's addresses.
+// If the lookups fail or find no such name, the host name is returned
+func lookupFQDN(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fqdnTimeout)
+	defer cancel()
+	addrs, err := fqdnResolver.LookupHost(ctx, name)
+	if err != nil {
+		return name
+	}
+	for _, addr := range addrs {
+		names, err := fqdnResolver.LookupAddr(ctx, addr)
+		if err != nil {
+			continue
+		}
+		for _, n := range names {
+			if n = strings.TrimSuffix(n, "."); strings.Contains(n, ".") {
+				return n
+			}
+		}
+	}
+	return name
+}
+
+// now returns the current time truncated to whole seconds, so it prints without fractions
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// getenv reads a variable directly from the process environment, returning an empty string if it is unset
+// It bypasses the Environment the template is rendered with, so variables filtered out of it
+// (by a profile, --fail-on-empty or an embedding program) are still readable through it
+func getenv(key string) string {
+	return os.Getenv(key)
+}
+
+// getenvOr reads a variable directly from the process environment, returning defaultValue if it is unset
+// Like getenv, it bypasses the Environment the template is rendered with
+func getenvOr(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// fileExists checks if a file or directory exists at the path
+// Stat errors other than not-exist, such as permission denied, are reported as false instead of panicking
+// so the function stays a pure predicate usable in conditions
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// glob returns the sorted names of all files matching the pattern, see filepath.Match for the syntax
+// Panics if the pattern is malformed
+func glob(pattern string) []string {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		panic(fmt.Errorf("could not match pattern '%s': %v", pattern, err))
+	}
+	sort.Strings(matches)
+	return matches
 }
 
 // fileExistOrDefault copies a default file to the destination path if the destination does not exist
@@ -466,22 +2180,85 @@ func fileExistOrDefault(destination string, defaultPath string) bool {
 		if err != nil {
 			panic(fmt.Errorf("could not read file permissions for '%s': %v", defaultPath, err))
 		}
-
-		r, err := os.Open(defaultPath)
-		if err != nil {
-			panic(fmt.Errorf("could not open file '%s': %v", defaultPath, err))
+		if err := copyFile(defaultPath, destination, fileInfo.Mode()); err != nil {
+			panic(err)
 		}
-		defer r.Close()
+	}
+	return true
+}
 
-		w, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileInfo.Mode())
-		if err != nil {
-			panic(fmt.Errorf("could not open file '%s': %v", destination, err))
-		}
-		defer w.Close()
+// defaultReadFileLimit is the maximum number of bytes readFile reads when no limit is given
+const defaultReadFileLimit = 10 << 20
 
-		if _, err := io.Copy(w, r); err != nil {
-			panic(fmt.Errorf("could not copy file '%s' to '%s': %v", defaultPath, destination, err))
-		}
+// readFile returns the contents of a file, relative paths are resolved against the working directory
+// An optional maxBytes overrides the default size limit of 10 MiB
+// Panics if the file cannot be read or is larger than the limit
+func readFile(path string, maxBytes ...int) string {
+	limit := defaultReadFileLimit
+	if len(maxBytes) > 0 {
+		limit = maxBytes[0]
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		panic(fmt.Errorf("could not resolve path '%s': %v", path, err))
+	}
+	f, err := os.Open(absPath)
+	if err != nil {
+		panic(fmt.Errorf("could not open file '%s': %v", absPath, err))
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		panic(fmt.Errorf("could not read file '%s': %v", absPath, err))
+	}
+	if len(data) > limit {
+		panic(fmt.Errorf("file '%s' is larger than %d bytes", absPath, limit))
+	}
+	return string(data)
+}
+
+// yamlFile reads a YAML file with readFile and returns the value at the dotted path, see dig
+// It returns nil if the path does not exist, an empty path returns the whole document
+// Panics if the file cannot be read or is not valid YAML
+func yamlFile(path, dotPath string) any {
+	var v any
+	if err := yaml.Unmarshal([]byte(readFile(path)), &v); err != nil {
+		panic(fmt.Errorf("could not parse yaml file '%s': %v", path, err))
+	}
+	return dig(dotPath, v)
+}
+
+// jsonFile reads a JSON file with readFile and returns the value at the dotted path, see dig
+// Numbers are float64 like with fromJson. It returns nil if the path does not exist, an empty path
+// returns the whole document
+// Panics if the file cannot be read or is not valid JSON
+func jsonFile(path, dotPath string) any {
+	var v any
+	if err := json.Unmarshal([]byte(readFile(path)), &v); err != nil {
+		panic(fmt.Errorf("could not parse json file '%s': %v", path, err))
+	}
+	return dig(dotPath, v)
+}
+
+// changedSince reports whether the sha256 checksum of content differs from the one stored in path+".sum"
+// A missing checksum file counts as changed, the new checksum is written whenever it differs
+// Panics if the checksum file cannot be read or written
+func changedSince(path, content string) bool {
+	sumPath := path + ".sum"
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+
+	stored, err := os.ReadFile(sumPath)
+	if err != nil && !os.IsNotExist(err) {
+		panic(fmt.Errorf("could not read checksum file '%s': %v", sumPath, err))
+	}
+	if err == nil && strings.TrimSpace(string(stored)) == checksum {
+		return false
+	}
+
+	if err := os.WriteFile(sumPath, []byte(checksum+"\n"), 0644); err != nil {
+		panic(fmt.Errorf("could not write checksum file '%s': %v", sumPath, err))
 	}
 	return true
 }
@@ -496,19 +2273,45 @@ func GetTemplateFunctions(env Environment) template.FuncMap {
 		"asStringOr":        env.AsStringOr,
 		"asStringSlice":     env.AsStringSlice,
 		"asStringSliceTrim": env.AsStringSliceTrim,
+		"asStringSliceN":    env.AsStringSliceN,
 		"asBool":            env.AsBool,
 		"asBoolOr":          env.AsBoolOr,
 		"asInt":             env.AsInt,
 		"asIntOr":           env.AsIntOr,
+		"asIntInRange":      env.AsIntInRange,
+		"asIntInRangeOr":    env.AsIntInRangeOr,
 		"asIntSlice":        env.AsIntSlice,
 		"asFloat":           env.AsFloat,
 		"asFloatOr":         env.AsFloatOr,
+		"asDuration":        env.AsDuration,
 		"asFloatSlice":      env.AsFloatSlice,
+		"asPercentileList":  env.AsPercentileList,
+		"asPercent":         env.AsPercent,
+		"asPercentOr":       env.AsPercentOr,
 		"asPort":            env.AsPort,
 		"asPortOr":          env.AsPortOr,
 		"asURL":             env.AsURL,
 		"asHostPort":        env.AsHostPort,
+		"host":              env.Host,
+		"port":              env.Port,
+		"asIP":              env.AsIP,
+		"asIPv4":            env.AsIPv4,
+		"asIPv6":            env.AsIPv6,
+		"asPath":            env.AsPath,
+		"asAbsPath":         env.AsAbsPath,
+		"asUUID":            env.AsUUID,
+		"asUUIDOr":          env.AsUUIDOr,
+		"asSemver":          env.AsSemver,
+		"asColor":           env.AsColor,
+		"asColorOr":         env.AsColorOr,
+		"asTimeZone":        env.AsTimeZone,
+		"asTimeZoneOr":      env.AsTimeZoneOr,
 		"sortAll":           env.SortAll,
+		"envMap":            env.Map,
+		"sortAllPrefix":     env.SortAllPrefix,
+		"envHash":           env.Hash,
+		"asStringOrFile":    env.AsStringOrFile,
+		"readSecret":        env.ReadSecret,
 		"exist":             env.Exist,
 		"existAndNotEmpty":  env.ExistAndNotEmpty,
 		"notExist":          env.NotExist,
@@ -522,35 +2325,445 @@ func GetTemplateFunctions(env Environment) template.FuncMap {
 		"hasSuffix":               hasSuffix,
 		"toLower":                 toLower,
 		"toUpper":                 toUpper,
+		"title":                   title,
+		"capitalize":              capitalize,
+		"repeat":                  repeat,
+		"padLeft":                 padLeft,
+		"padRight":                padRight,
+		"padNum":                  padNum,
+		"wrap":                    wrap,
+		"wrapWith":                wrapWith,
+		"format":                  format,
+		"sprintfStrict":           sprintfStrict,
+		"substr":                  substr,
+		"trunc":                   trunc,
+		"truncBytes":              truncBytes,
+		"reverse":                 reverse,
 		"trim":                    trim,
 		"trimLeft":                trimLeft,
 		"trimRight":               trimRight,
 		"trimSpace":               trimSpace,
+		"nospace":                 nospace,
+		"trimPrefix":              trimPrefix,
+		"trimSuffix":              trimSuffix,
+		"quote":                   quote,
+		"squote":                  squote,
+		"shellQuote":              shellQuote,
+		"htmlEscape":              htmlEscape,
+		"htmlUnescape":            htmlUnescape,
+		"mask":                    mask,
+		"regexMatch":              regexMatch,
+		"regexReplace":            regexReplace,
 		"isEmpty":                 isEmpty,
+		"isInt":                   isInt,
+		"isFloat":                 isFloat,
+		"isURL":                   isURL,
+		"isPort":                  isPort,
+		"isIP":                    isIP,
 		"isNotEmpty":              isNotEmpty,
+		"semverCompare":           semverCompare,
 
 		// Encoding and utility functions
-		"base64Decode": base64Decode,
-		"base64Encode": base64Encode,
-		"hash":         hash,
-		"sequence":     sequence,
+		"base64Decode":    base64Decode,
+		"base64Encode":    base64Encode,
+		"base64URLDecode": base64URLDecode,
+		"base64URLEncode": base64URLEncode,
+		"base32Decode":    base32Decode,
+		"base32Encode":    base32Encode,
+		"gzip":            gzipEncode,
+		"gunzip":          gzipDecode,
+		"hash":            hash,
+		"pickSeeded":      pickSeeded,
+		"crc32":           crc32Checksum,
+		"bcrypt":          bcryptHash,
+		"sequence":        sequence,
+		"backoffSeq":      backoffSeq,
+		"uniqFold":        uniqCaseInsensitive,
+		"count":           count,
+		"inSlice":         inSlice,
+		"indexOf":         indexOf,
+		"zip":             zip,
+		"chunk":           chunk,
+		"reverseList":     reverseList,
+		"coalesce":        coalesce,
+		"empty":           empty,
+		"ternary":         ternary,
+		"compact":         compact,
+		"lines":           lines,
+		"unlines":         unlines,
+		"toString":        toString,
+		"toInt":           toInt,
+		"toFloat":         toFloat,
+		"toBool":          toBool,
+		"abs":             abs,
+		"round":           round,
+		"ceil":            ceil,
+		"floor":           floor,
+		"topoSort":        topoSort,
+
+		// Output formats
+		"dict":            dict,
+		"merge":           merge,
+		"mergeDeep":       mergeDeep,
+		"bundle":          bundle,
+		"toJson":          toJson,
+		"fromJson":        fromJson,
+		"toToml":          toToml,
+		"toIni":           toIni,
+		"fromIni":         fromIni,
+		"dig":             dig,
+		"hasKey":          hasKey,
+		"hasKeyAny":       hasKeyAny,
+		"toPowerShellEnv": toPowerShellEnv,
+		"toBatchEnv":      toBatchEnv,
+
+		// Process environment
+		"getenv":   getenv,
+		"getenvOr": getenvOr,
+
+		// Time
+		"now": now,
+
+		// System
+		"numCPU":        numCPU,
+		"totalMemBytes": totalMemBytes,
+		"hostname":      hostname,
+		"fqdn":          fqdn,
 
 		// File
+		"fileExists":         fileExists,
 		"fileExistOrDefault": fileExistOrDefault,
+		"glob":               glob,
+		"changedSince":       changedSince,
+		"readFile":           readFile,
+		"yamlFile":           yamlFile,
+		"jsonFile":           jsonFile,
+	}
+}
+
+// Options controls optional behaviour of the template rendering
+type Options struct {
+	// AnnotateSource prefixes each output line with a comment naming the template that produced it
+	AnnotateSource bool
+	// CommentPrefix is the comment syntax used for source annotations, env comments and the banner
+	CommentPrefix string
+	// IncludeEnvComments makes withSource append a comment naming the environment key to the value
+	IncludeEnvComments bool
+	// FailOnEmpty treats keys with an empty or whitespace only value as missing,
+	// so accessors and field access of such keys fail instead of rendering an empty value
+	FailOnEmpty bool
+	// HTML parses the template with html/template for contextual auto-escaping
+	HTML bool
+	// Trim removes leading and trailing whitespace from the rendered output
+	Trim bool
+	// TrimBlankLines collapses runs of blank lines in the rendered output into a single empty line
+	TrimBlankLines bool
+	// EnsureNewline makes the rendered output end with exactly one newline
+	EnsureNewline bool
+	// NoTrailingNewline removes all newlines from the end of the rendered output
+	NoTrailingNewline bool
+	// BackupSuffix, if set, makes writing an output file first copy the existing file to its name with this suffix
+	BackupSuffix string
+	// Chomp makes lines that consist solely of a control action such as if, range or end emit nothing
+	Chomp bool
+	// Values, if set, are available to templates as .Values next to the environment variables
+	Values map[string]any
+	// Banner, if set, is rendered as a template and prepended to the output as comment lines
+	Banner string
+	// ContinueOnError makes RenderDirectory render the remaining files after a failure and report all failures at the end
+	ContinueOnError bool
+	// BaseDir is the directory template names are relative to, includeIf resolves file paths against
+	// the directory of the calling template within it. Empty means the current working directory
+	BaseDir string
+}
+
+// renderer is the subset of text/template and html/template used to execute a parsed template
+type renderer interface {
+	Execute(w io.Writer, data any) error
+	ExecuteTemplate(w io.Writer, name string, data any) error
+}
+
+// maxNestingDepth limits how deeply include and tpl calls may nest, so a partial including itself
+// fails instead of exhausting the stack
+const maxNestingDepth = 100
+
+// nestingDepthError reports that include or tpl calls nested deeper than maxNestingDepth
+type nestingDepthError struct {
+	name string
+}
+
+func (e *nestingDepthError) Error() string {
+	return fmt.Sprintf("template nesting depth limit of %d exceeded at '%s'", maxNestingDepth, e.name)
+}
+
+// templateRenderer renders templates for one environment and set of options.
+// The template functions are built once and every template is parsed into a clone of a shared base template,
+// so rendering many templates does not rebuild and revalidate the function map each time.
+type templateRenderer struct {
+	env  Environment
+	opts Options
+	text *template.Template
+	html *htmltemplate.Template
+	// unfiltered is the environment before FailOnEmpty removed the empty values, nil without FailOnEmpty
+	unfiltered Environment
+	// depth is the number of include and tpl calls currently being executed
+	depth int
+	// data is the value templates are executed with, see templateData
+	data map[string]any
+	// funcNames are the sorted names of the template functions, used to suggest one for a typo
+	funcNames []string
+}
+
+// newTemplateRenderer builds the template functions for the environment and the base template
+func newTemplateRenderer(env Environment, opts Options) *templateRenderer {
+	var unfiltered Environment
+	if opts.FailOnEmpty {
+		unfiltered = env
+		env = env.WithoutEmpty()
+	}
+	funcs := GetTemplateFunctions(env)
+	funcs["withSource"] = func(key string) string {
+		if opts.IncludeEnvComments {
+			return env.AsStringWithSource(key, opts.CommentPrefix)
+		}
+		return env.AsString(key)
+	}
+	// include, includeIf and tpl need the template they are called from, they are replaced on every clone
+	funcs["include"] = func(partial string, data any) any {
+		panic(fmt.Errorf("include is not bound to a template"))
+	}
+	funcs["includeIf"] = func(condition bool, path string, data ...any) any {
+		panic(fmt.Errorf("includeIf is not bound to a template"))
+	}
+	funcs["tpl"] = func(s string) any {
+		panic(fmt.Errorf("tpl is not bound to a template"))
+	}
+
+	missingKey := "missingkey=default"
+	if opts.FailOnEmpty {
+		missingKey = "missingkey=error"
+	}
+	r := &templateRenderer{env: env, unfiltered: unfiltered, opts: opts, data: templateData(env, opts.Values), funcNames: slices.Sorted(maps.Keys(funcs))}
+	if opts.HTML {
+		r.html = htmltemplate.New("").Option(missingKey).Funcs(htmltemplate.FuncMap(funcs))
+	} else {
+		r.text = template.New("").Option(missingKey).Funcs(funcs)
+	}
+	return r
+}
+
+// parse parses the named template content with text/template or, if opts.HTML is set, html/template
+func (r *templateRenderer) parse(name, templateContent string) (renderer, error) {
+	var tmpl renderer
+	include := func(partial string, data any) any {
+		if r.depth >= maxNestingDepth {
+			panic(&nestingDepthError{name: partial})
+		}
+		r.depth++
+		defer func() { r.depth-- }()
+
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, partial, data); err != nil {
+			// report a runaway recursion once instead of wrapping it at every level
+			var depthErr *nestingDepthError
+			if errors.As(err, &depthErr) {
+				panic(depthErr)
+			}
+			panic(fmt.Errorf("could not include template '%s': %v", partial, err))
+		}
+		output := buf.String()
+		if r.opts.AnnotateSource {
+			output = sourceBeginMarker + partial + "\x00" + output + sourceEndMarker
+		}
+		if r.opts.HTML {
+			// already escaped by the partial itself
+			return htmltemplate.HTML(output)
+		}
+		return output
+	}
+	includeIf := func(condition bool, path string, data ...any) any {
+		if !condition {
+			return ""
+		}
+		if len(data) > 1 {
+			panic(fmt.Errorf("includeIf takes at most one data argument, got %d", len(data)))
+		}
+		var context any = r.data
+		if len(data) == 1 {
+			context = data[0]
+		}
+		return r.includeFile(name, path, context)
+	}
+	tpl := func(s string) any {
+		if r.depth >= maxNestingDepth {
+			panic(&nestingDepthError{name: "tpl"})
+		}
+		r.depth++
+		defer func() { r.depth-- }()
+
+		t, err := r.parse("tpl", s)
+		if err != nil {
+			panic(fmt.Errorf("could not parse tpl value: %v", err))
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, r.data); err != nil {
+			var depthErr *nestingDepthError
+			if errors.As(err, &depthErr) {
+				panic(depthErr)
+			}
+			panic(fmt.Errorf("could not render tpl value: %v", err))
+		}
+		if r.opts.HTML {
+			// already escaped by the value's own template
+			return htmltemplate.HTML(buf.String())
+		}
+		return buf.String()
+	}
+
+	if r.opts.Chomp {
+		templateContent = chompActions(templateContent)
+	}
+
+	if r.opts.HTML {
+		base, err := r.html.Clone()
+		if err != nil {
+			return nil, err
+		}
+		t, err := base.New(name).Funcs(htmltemplate.FuncMap{"include": include, "includeIf": includeIf, "tpl": tpl}).Parse(templateContent)
+		if err != nil {
+			return nil, suggestFunction(err, r.funcNames)
+		}
+		tmpl = t
+		return tmpl, nil
+	}
+	base, err := r.text.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t, err := base.New(name).Funcs(template.FuncMap{"include": include, "includeIf": includeIf, "tpl": tpl}).Parse(templateContent)
+	if err != nil {
+		return nil, suggestFunction(err, r.funcNames)
+	}
+	tmpl = t
+	return tmpl, nil
+}
+
+// includeFile renders the template file at path with data and returns the output. A relative path is resolved
+// against the directory of the template named from, which itself is relative to opts.BaseDir
+func (r *templateRenderer) includeFile(from, path string, data any) any {
+	if r.depth >= maxNestingDepth {
+		panic(&nestingDepthError{name: path})
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	name := path
+	if !filepath.IsAbs(path) {
+		name = filepath.Join(filepath.Dir(from), path)
+	}
+	file := name
+	if !filepath.IsAbs(name) {
+		file = filepath.Join(r.opts.BaseDir, name)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(fmt.Errorf("could not include file '%s': %v", path, err))
+	}
+	t, err := r.parse(name, string(content))
+	if err != nil {
+		panic(fmt.Errorf("could not parse included file '%s': %v", path, err))
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		var depthErr *nestingDepthError
+		if errors.As(err, &depthErr) {
+			panic(depthErr)
+		}
+		panic(fmt.Errorf("could not include file '%s': %v", path, err))
+	}
+	output := buf.String()
+	if r.opts.AnnotateSource {
+		output = sourceBeginMarker + name + "\x00" + output + sourceEndMarker
+	}
+	if r.opts.HTML {
+		// already escaped by the included template itself
+		return htmltemplate.HTML(output)
+	}
+	return output
+}
+
+// render parses the named template content and writes the output to w.
+// Only source annotation and the whitespace options require the output to be buffered first.
+func (r *templateRenderer) render(w io.Writer, name, templateContent string) error {
+	tmpl, err := r.parse(name, templateContent)
+	if err != nil {
+		return fmt.Errorf("error parsing template: %w", err)
+	}
+	banner, err := r.renderBanner()
+	if err != nil {
+		return err
+	}
+	if !r.opts.AnnotateSource && !trimsOutput(r.opts) {
+		if _, err := io.WriteString(w, banner); err != nil {
+			return err
+		}
+		if err := tmpl.Execute(w, r.data); err != nil {
+			return fmt.Errorf("error executing template: %w", emptyValueError(err, r.unfiltered))
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r.data); err != nil {
+		return fmt.Errorf("error executing template: %w", emptyValueError(err, r.unfiltered))
+	}
+	output := trimOutput(buf.String(), r.opts)
+	if r.opts.AnnotateSource {
+		output = annotateSource(output, name, r.opts.CommentPrefix)
 	}
+	_, err = io.WriteString(w, banner+output)
+	return err
+}
+
+// renderBanner renders opts.Banner as comment lines, it returns an empty string without a banner
+func (r *templateRenderer) renderBanner() (string, error) {
+	if r.opts.Banner == "" {
+		return "", nil
+	}
+	tmpl, err := r.parse("banner", r.opts.Banner)
+	if err != nil {
+		return "", fmt.Errorf("error parsing banner: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r.data); err != nil {
+		return "", fmt.Errorf("error executing banner: %w", emptyValueError(err, r.unfiltered))
+	}
+	return commentLines(buf.String(), r.opts.CommentPrefix), nil
 }
 
 // RenderTemplate processes the template string with the given environment.
 // It returns the rendered output or an error if template parsing or execution fails.
 func RenderTemplate(templateContent string, env Environment) (string, error) {
-	tmpl := template.New("envTemplate").Funcs(GetTemplateFunctions(env))
-	parsedTmpl, err := tmpl.Parse(templateContent)
-	if err != nil {
-		return "", fmt.Errorf("error parsing template: %w", err)
-	}
+	return RenderTemplateWithOptions("envTemplate", templateContent, env, Options{})
+}
+
+// RenderTemplateTo processes the template string with the given environment and writes the output to w.
+// The output is not buffered, so w may have received partial output when an error is returned.
+func RenderTemplateTo(w io.Writer, templateContent string, env Environment) error {
+	return RenderTemplateWithOptionsTo(w, "envTemplate", templateContent, env, Options{})
+}
+
+// RenderTemplateWithOptions processes the named template string with the given environment and options.
+// It returns the rendered output or an error if template parsing or execution fails.
+func RenderTemplateWithOptions(name string, templateContent string, env Environment, opts Options) (string, error) {
 	var buf bytes.Buffer
-	if err := parsedTmpl.Execute(&buf, env); err != nil {
-		return "", fmt.Errorf("error executing template: %w", err)
+	if err := RenderTemplateWithOptionsTo(&buf, name, templateContent, env, opts); err != nil {
+		return "", err
 	}
 	return buf.String(), nil
 }
+
+// RenderTemplateWithOptionsTo processes the named template string with the given environment and options
+// and writes the output to w.
+func RenderTemplateWithOptionsTo(w io.Writer, name string, templateContent string, env Environment, opts Options) error {
+	return newTemplateRenderer(env, opts).render(w, name, templateContent)
+}
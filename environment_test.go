@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
 )
 
 func TestNewEnvironment(t *testing.T) {
@@ -30,6 +43,228 @@ func TestNewEnvironment(t *testing.T) {
 	}
 }
 
+func TestEnvironment_Expand(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     Environment
+		strict  bool
+		want    Environment
+		wantErr string
+	}{
+		{
+			name: "braces and bare references",
+			env:  Environment{"HOST": "example.com", "PORT": "8080", "URL": "http://${HOST}:$PORT/"},
+			want: Environment{"HOST": "example.com", "PORT": "8080", "URL": "http://example.com:8080/"},
+		},
+		{
+			name: "nested references",
+			env:  Environment{"A": "${B}/a", "B": "${C}/b", "C": "c"},
+			want: Environment{"A": "c/b/a", "B": "c/b", "C": "c"},
+		},
+		{
+			name: "literal dollar",
+			env:  Environment{"PRICE": "$$5", "PLAIN": "no refs"},
+			want: Environment{"PRICE": "$5", "PLAIN": "no refs"},
+		},
+		{
+			name:   "positional and special sequences are literal",
+			env:    Environment{"PRICE": "cost $5", "SPECIAL": "$? ${1} $", "HOST": "h"},
+			strict: true,
+			want:   Environment{"PRICE": "cost $5", "SPECIAL": "$? ${1} $", "HOST": "h"},
+		},
+		{
+			name: "undefined reference is empty",
+			env:  Environment{"URL": "http://${HOST}/"},
+			want: Environment{"URL": "http:///"},
+		},
+		{
+			name:    "undefined reference strict",
+			env:     Environment{"URL": "http://${HOST}/"},
+			strict:  true,
+			wantErr: "variable 'URL' references undefined variable 'HOST'",
+		},
+		{
+			name:    "self reference",
+			env:     Environment{"A": "$A"},
+			wantErr: "variable reference cycle: A -> A",
+		},
+		{
+			name:    "cycle",
+			env:     Environment{"A": "${B}", "B": "${C}", "C": "${A}", "D": "x"},
+			wantErr: "variable reference cycle: A -> B -> C -> A",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.env.Expand(tc.strict)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("Expand() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expand() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expand() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEnvironment_SortAllPrefix(t *testing.T) {
+	env := Environment{
+		"NGINX_PORT":    "80",
+		"NGINX_HOST":    "example.com",
+		"NGINX":         "bare",
+		"APP_NGINX_KEY": "nested",
+		"DB_HOST":       "localhost",
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		wanted map[string]string
+	}{
+		{name: "matching prefix", prefix: "NGINX_", wanted: map[string]string{"NGINX_HOST": "example.com", "NGINX_PORT": "80"}},
+		{name: "no match", prefix: "REDIS_", wanted: map[string]string{}},
+		{name: "empty prefix", prefix: "", wanted: env.SortAll()},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := env.SortAllPrefix(tc.prefix)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("SortAllPrefix(%q) = %v, want %v", tc.prefix, got, tc.wanted)
+			}
+		})
+	}
+
+	got, err := RenderTemplate(`{{ range $k, $v := sortAllPrefix "NGINX_" }}{{ $k }}={{ $v }};{{ end }}`, env)
+	if err != nil || got != "NGINX_HOST=example.com;NGINX_PORT=80;" {
+		t.Errorf("sortAllPrefix in template = %q, %v", got, err)
+	}
+}
+
+func TestNewEnvironmentWithProfile(t *testing.T) {
+	envMap := map[string]string{
+		"DB_HOST":      "localhost",
+		"DB_PORT":      "5432",
+		"PROD_DB_HOST": "db.prod",
+		"PROD_ONLY":    "yes",
+		"PROD_":        "ignored",
+	}
+
+	env := NewEnvironmentWithProfile(envMap, "PROD")
+	want := Environment{
+		"DB_HOST":      "db.prod",
+		"DB_PORT":      "5432",
+		"ONLY":         "yes",
+		"PROD_DB_HOST": "db.prod",
+		"PROD_ONLY":    "yes",
+		"PROD_":        "ignored",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("NewEnvironmentWithProfile = %v, want %v", env, want)
+	}
+	if envMap["DB_HOST"] != "localhost" {
+		t.Errorf("NewEnvironmentWithProfile modified the source map")
+	}
+
+	noProfile := NewEnvironmentWithProfile(envMap, "")
+	if noProfile.AsString("DB_HOST") != "localhost" {
+		t.Errorf("NewEnvironmentWithProfile without profile should not override keys")
+	}
+}
+
+func TestNewEnvironmentWithPrefix(t *testing.T) {
+	envMap := map[string]string{
+		"APP_PORT": "8080",
+		"APP_HOST": "example.com",
+		"HOST":     "localhost",
+		"PATH":     "/usr/bin",
+		"APP_":     "ignored",
+	}
+
+	env := NewEnvironmentWithPrefix(envMap, "APP_")
+	want := Environment{
+		"APP_PORT": "8080",
+		"APP_HOST": "example.com",
+		"PORT":     "8080",
+		"HOST":     "example.com",
+		"PATH":     "/usr/bin",
+		"APP_":     "ignored",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("NewEnvironmentWithPrefix = %v, want %v", env, want)
+	}
+	if envMap["HOST"] != "localhost" {
+		t.Errorf("NewEnvironmentWithPrefix modified the source map")
+	}
+
+	got, err := RenderTemplate(`{{ .PORT }} {{ asString "HOST" }}`, env)
+	if err != nil || got != "8080 example.com" {
+		t.Errorf("stripped keys in template = %q, %v", got, err)
+	}
+
+	if noPrefix := NewEnvironmentWithPrefix(envMap, ""); !reflect.DeepEqual(noPrefix, Environment(envMap)) {
+		t.Errorf("NewEnvironmentWithPrefix without prefix should not add keys")
+	}
+}
+
+func TestEnvironmentHash(t *testing.T) {
+	env := Environment{"A": "1", "B": "2", "C": "three"}
+	// a fixed value, so a change of the hashed format that would change fingerprints is noticed
+	want := "bd4dfe2b0c2fd8cd2b3ec3e72c8c00fdd8509c9f20dacdcfe01b1e555ad43f52"
+	if got := env.Hash("sha256"); got != want {
+		t.Fatalf("Hash(sha256) = %q, want %q", got, want)
+	}
+
+	// maps iterate in random order, the hash must not depend on it
+	for range 20 {
+		same := Environment{}
+		for k, v := range env {
+			same[k] = v
+		}
+		if got := same.Hash("sha256"); got != want {
+			t.Fatalf("Hash(sha256) = %q on a copy, want %q", got, want)
+		}
+	}
+	if got := env.Hash("SHA256"); got != want {
+		t.Errorf("Hash(SHA256) = %q, want %q", got, want)
+	}
+
+	different := []Environment{
+		{"A": "1", "B": "2", "C": "3"},
+		{"A": "1", "B": "2"},
+		{"A": "1", "B": "2", "C": "three", "D": ""},
+		{"A": "1\"=\"B", "C": "three"},
+		{"A": "1\nB=2", "C": "three"},
+	}
+	for _, d := range different {
+		if d.Hash("sha256") == want {
+			t.Errorf("Hash of %v equals the hash of %v", d, env)
+		}
+	}
+	if (Environment{"A": "1\nB=2"}).Hash("md5") == (Environment{"A": "1", "B": "2"}).Hash("md5") {
+		t.Errorf("Expected values containing line breaks not to collide with separate keys")
+	}
+
+	got, err := RenderTemplate(`{{ envHash "sha256" }}`, env)
+	if err != nil || got != want {
+		t.Errorf("envHash in template = %q, %v", got, err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Hash did not panic for an unsupported algorithm")
+		}
+	}()
+	env.Hash("crc64")
+}
+
 func TestExist(t *testing.T) {
 	env := Environment{"KEY": "value", "EMPTY": ""}
 
@@ -128,6 +363,58 @@ func TestAsStringOr(t *testing.T) {
 	}
 }
 
+func TestAsStringWithSource(t *testing.T) {
+	env := Environment{"NAME": "zep"}
+
+	if got := env.AsStringWithSource("NAME", "#"); got != "zep # from NAME" {
+		t.Errorf("AsStringWithSource(%q) = %q, want %q", "NAME", got, "zep # from NAME")
+	}
+	if got := env.AsStringWithSource("NAME", "//"); got != "zep // from NAME" {
+		t.Errorf("AsStringWithSource(%q) = %q, want %q", "NAME", got, "zep // from NAME")
+	}
+	if got := env.AsStringWithSource("NAME", ""); got != "zep from NAME" {
+		t.Errorf("AsStringWithSource(%q) = %q, want %q", "NAME", got, "zep from NAME")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("AsStringWithSource did not panic for missing key")
+		}
+	}()
+	env.AsStringWithSource("NONEXISTENT", "#")
+}
+
+func TestRenderTemplateWithSource(t *testing.T) {
+	env := Environment{"NAME": "zep"}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    string
+		wantErr bool
+	}{
+		{name: "with env comments", opts: Options{IncludeEnvComments: true, CommentPrefix: "#"}, want: "name = zep # from NAME", wantErr: false},
+		{name: "without env comments", opts: Options{CommentPrefix: "#"}, want: "name = zep", wantErr: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderTemplateWithOptions("config", `name = {{ withSource "NAME" }}`, env, tc.opts)
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	_, err := RenderTemplateWithOptions("config", `{{ withSource "MISSING" }}`, env, Options{IncludeEnvComments: true})
+	if err == nil || !strings.Contains(err.Error(), "MISSING") {
+		t.Errorf("expected error naming the missing key, got %v", err)
+	}
+}
+
 func TestAsStringSlice(t *testing.T) {
 	env := Environment{
 		"COMMA_LIST": "a,b,c",
@@ -202,6 +489,41 @@ func TestAsStringSliceTrim(t *testing.T) {
 	}
 }
 
+func TestAsStringSliceN(t *testing.T) {
+	env := Environment{"PAIR": "key=some=value=with=equals"}
+
+	tests := []struct {
+		name      string
+		key       string
+		n         int
+		want      []string
+		wantPanic bool
+	}{
+		{name: "keep remainder", key: "PAIR", n: 2, want: []string{"key", "some=value=with=equals"}, wantPanic: false},
+		{name: "whole string", key: "PAIR", n: 1, want: []string{"key=some=value=with=equals"}, wantPanic: false},
+		{name: "zero elements", key: "PAIR", n: 0, want: nil, wantPanic: false},
+		{name: "all elements", key: "PAIR", n: -1, want: []string{"key", "some", "value", "with", "equals"}, wantPanic: false},
+		{name: "non-existent key", key: "NONEXISTENT", n: 2, want: nil, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsStringSliceN did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsStringSliceN(tc.key, "=", tc.n)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("AsStringSliceN(%q, %q, %d) = %v, want %v", tc.key, "=", tc.n, got, tc.want)
+			}
+		})
+	}
+}
+
 func TestAsBool(t *testing.T) {
 	env := Environment{
 		"TRUE1":   "true",
@@ -352,6 +674,118 @@ func TestAsHostPort(t *testing.T) {
 	}
 }
 
+func TestAsHostPortParts(t *testing.T) {
+	env := Environment{
+		"HOST_PORT":    "localhost:8080",
+		"IPV4":         "10.0.0.1:53",
+		"IPV6":         "[::1]:8080",
+		"IPV6_FULL":    "[2001:db8::1]:443",
+		"NO_PORT":      "localhost",
+		"OUT_OF_RANGE": "localhost:70000",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		wantHost  string
+		wantPort  int
+		wantPanic bool
+	}{
+		{name: "hostname", key: "HOST_PORT", wantHost: "localhost", wantPort: 8080},
+		{name: "ipv4", key: "IPV4", wantHost: "10.0.0.1", wantPort: 53},
+		{name: "ipv6 in brackets", key: "IPV6", wantHost: "::1", wantPort: 8080},
+		{name: "full ipv6 in brackets", key: "IPV6_FULL", wantHost: "2001:db8::1", wantPort: 443},
+		{name: "missing port", key: "NO_PORT", wantPanic: true},
+		{name: "port out of range", key: "OUT_OF_RANGE", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsHostPortParts did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			host, port := env.AsHostPortParts(tc.key)
+			if host != tc.wantHost || port != tc.wantPort {
+				t.Errorf("AsHostPortParts(%q) = %q, %d, want %q, %d", tc.key, host, port, tc.wantHost, tc.wantPort)
+			}
+			if env.Host(tc.key) != tc.wantHost || env.Port(tc.key) != tc.wantPort {
+				t.Errorf("Host(%q), Port(%q) = %q, %d", tc.key, tc.key, env.Host(tc.key), env.Port(tc.key))
+			}
+		})
+	}
+
+	got, err := RenderTemplate(`server_name {{ host "IPV6" }}; listen {{ port "IPV6" }};`, env)
+	if err != nil || got != "server_name ::1; listen 8080;" {
+		t.Errorf("host and port in template = %q, %v", got, err)
+	}
+}
+
+func TestAsIP(t *testing.T) {
+	env := Environment{
+		"IPV4":        "192.168.1.10",
+		"IPV6":        "2001:DB8::0001",
+		"LOOPBACK6":   "::1",
+		"MAPPED":      "::ffff:1.2.3.4",
+		"INVALID":     "256.1.1.1",
+		"HOSTNAME":    "localhost",
+		"WITH_PORT":   "127.0.0.1:80",
+		"WITH_ZONE":   "fe80::1%eth0",
+		"EMPTY_VALUE": "",
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantV4  bool
+		wantV6  bool
+		invalid bool
+	}{
+		{name: "ipv4", key: "IPV4", want: "192.168.1.10", wantV4: true},
+		{name: "ipv6 is canonicalized", key: "IPV6", want: "2001:db8::1", wantV6: true},
+		{name: "ipv6 loopback", key: "LOOPBACK6", want: "::1", wantV6: true},
+		{name: "ipv4-mapped ipv6 counts as ipv4", key: "MAPPED", want: "1.2.3.4", wantV4: true},
+		{name: "octet out of range", key: "INVALID", invalid: true},
+		{name: "hostname", key: "HOSTNAME", invalid: true},
+		{name: "with port", key: "WITH_PORT", invalid: true},
+		{name: "with zone", key: "WITH_ZONE", invalid: true},
+		{name: "empty value", key: "EMPTY_VALUE", invalid: true},
+		{name: "non-existent key", key: "NONEXISTENT", invalid: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			accessors := []struct {
+				name   string
+				fn     func(string) string
+				panics bool
+			}{
+				{name: "AsIP", fn: env.AsIP, panics: tc.invalid},
+				{name: "AsIPv4", fn: env.AsIPv4, panics: !tc.wantV4},
+				{name: "AsIPv6", fn: env.AsIPv6, panics: !tc.wantV6},
+			}
+			for _, a := range accessors {
+				func() {
+					defer func() {
+						if r := recover(); (r != nil) != a.panics {
+							t.Errorf("%s(%q) panic = %v, want panic %v", a.name, tc.key, r, a.panics)
+						}
+					}()
+					if got := a.fn(tc.key); got != tc.want {
+						t.Errorf("%s(%q) = %q, want %q", a.name, tc.key, got, tc.want)
+					}
+				}()
+			}
+		})
+	}
+}
+
 func TestAsInt(t *testing.T) {
 	env := Environment{
 		"POSITIVE": "123",
@@ -522,6 +956,47 @@ func TestAsFloatOr(t *testing.T) {
 	}
 }
 
+func TestAsDuration(t *testing.T) {
+	env := Environment{
+		"TIMEOUT":  "1m30s",
+		"SHORT":    "250ms",
+		"NO_UNIT":  "30",
+		"INVALID":  "soon",
+		"NEGATIVE": "-5s",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      time.Duration
+		wantPanic bool
+	}{
+		{name: "minutes and seconds", key: "TIMEOUT", want: 90 * time.Second, wantPanic: false},
+		{name: "milliseconds", key: "SHORT", want: 250 * time.Millisecond, wantPanic: false},
+		{name: "negative", key: "NEGATIVE", want: -5 * time.Second, wantPanic: false},
+		{name: "missing unit", key: "NO_UNIT", want: 0, wantPanic: true},
+		{name: "invalid value", key: "INVALID", want: 0, wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: 0, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsDuration did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsDuration(tc.key)
+			if got != tc.want {
+				t.Errorf("AsDuration(%q) = %v, want %v", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
 func TestAsFloatSlice(t *testing.T) {
 	env := Environment{
 		"VALID":  "1.1,2.2,3.3",
@@ -560,23 +1035,29 @@ func TestAsFloatSlice(t *testing.T) {
 	}
 }
 
-func TestAsPort(t *testing.T) {
+func TestAsPercentileList(t *testing.T) {
 	env := Environment{
-		"VALID_PORT":    "8080",
-		"INVALID_PORT1": "invalid-port",
-		"INVALID_PORT2": "999999",
+		"VALID":        "99, 50,90,95",
+		"DUPLICATE":    "99,50,99,99.9",
+		"OUT_OF_RANGE": "50,101",
+		"ZERO":         "0,50",
+		"INVALID":      "50,p99",
+		"NAN":          "50,NaN,99",
 	}
 
 	tests := []struct {
 		name      string
 		key       string
-		want      int
+		want      []float64
 		wantPanic bool
 	}{
-		{name: "valid port", key: "VALID_PORT", want: 8080, wantPanic: false},
-		{name: "invalid port", key: "INVALID_PORT1", want: 0, wantPanic: true},
-		{name: "out of range port", key: "INVALID_PORT2", want: 0, wantPanic: true},
-		{name: "non-existent key", key: "NONEXISTENT", want: 0, wantPanic: true},
+		{name: "valid sorted", key: "VALID", want: []float64{50, 90, 95, 99}, wantPanic: false},
+		{name: "duplicate", key: "DUPLICATE", want: []float64{50, 99, 99.9}, wantPanic: false},
+		{name: "out of range", key: "OUT_OF_RANGE", want: nil, wantPanic: true},
+		{name: "zero", key: "ZERO", want: nil, wantPanic: true},
+		{name: "invalid element", key: "INVALID", want: nil, wantPanic: true},
+		{name: "not a number", key: "NAN", want: nil, wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: nil, wantPanic: true},
 	}
 
 	for _, tc := range tests {
@@ -584,336 +1065,3360 @@ func TestAsPort(t *testing.T) {
 			if tc.wantPanic {
 				defer func() {
 					if r := recover(); r == nil {
-						t.Errorf("AsPort did not panic for key %s", tc.key)
+						t.Errorf("AsPercentileList did not panic for key %s", tc.key)
 					}
 				}()
 			}
 
-			got := env.AsPort(tc.key)
-			if got != tc.want {
-				t.Errorf("AsPort(%q) = %d, want %d", tc.key, got, tc.want)
+			got := env.AsPercentileList(tc.key)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("AsPercentileList(%q) = %v, want %v", tc.key, got, tc.want)
 			}
 		})
 	}
+
+	defer func() {
+		if err, _ := recover().(error); err == nil || !strings.Contains(err.Error(), "on key 'NAN'") {
+			t.Errorf("AsPercentileList(NAN) error = %v, want it to name the key", err)
+		}
+	}()
+	env.AsPercentileList("NAN")
 }
 
-func TestAsPortOr(t *testing.T) {
+func TestAsPercent(t *testing.T) {
 	env := Environment{
-		"VALID_PORT":   "8080",
-		"INVALID_PORT": "invalid-port",
-		"OUT_OF_RANGE": "999999",
+		"SUFFIX":       "75%",
+		"BARE":         "75",
+		"FRACTIONAL":   "0.5%",
+		"SPACED":       " 12.5 % ",
+		"ZERO":         "0%",
+		"FULL":         "100%",
+		"OUT_OF_RANGE": "101%",
+		"NEGATIVE":     "-1%",
+		"INVALID":      "high",
+		"NAN":          "NaN%",
 	}
 
 	tests := []struct {
-		name         string
-		key          string
-		defaultValue int
-		want         int
+		name      string
+		key       string
+		want      float64
+		wantPanic bool
 	}{
-		{name: "existing valid", key: "VALID_PORT", defaultValue: 999, want: 8080},
-		{name: "existing invalid", key: "INVALID_PORT", defaultValue: 999, want: 999},
-		{name: "out of range", key: "OUT_OF_RANGE", defaultValue: 999, want: 999},
-		{name: "non-existent key", key: "NONEXISTENT", defaultValue: 999, want: 999},
+		{name: "with suffix", key: "SUFFIX", want: 0.75, wantPanic: false},
+		{name: "bare number", key: "BARE", want: 0.75, wantPanic: false},
+		{name: "fractional percent", key: "FRACTIONAL", want: 0.005, wantPanic: false},
+		{name: "spaces", key: "SPACED", want: 0.125, wantPanic: false},
+		{name: "zero", key: "ZERO", want: 0, wantPanic: false},
+		{name: "hundred", key: "FULL", want: 1, wantPanic: false},
+		{name: "out of range", key: "OUT_OF_RANGE", want: 0, wantPanic: true},
+		{name: "negative", key: "NEGATIVE", want: 0, wantPanic: true},
+		{name: "invalid", key: "INVALID", want: 0, wantPanic: true},
+		{name: "not a number", key: "NAN", want: 0, wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: 0, wantPanic: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := env.AsPortOr(tc.key, tc.defaultValue)
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsPercent did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsPercent(tc.key)
 			if got != tc.want {
-				t.Errorf("AsPortOr(%q, %v) = %v, want %v", tc.key, tc.defaultValue, got, tc.want)
+				t.Errorf("AsPercent(%q) = %v, want %v", tc.key, got, tc.want)
 			}
 		})
 	}
 }
 
-func Test_isEmpty(t *testing.T) {
+func TestAsPercentOr(t *testing.T) {
+	env := Environment{"VALID": "80%", "INVALID": "200%", "NAN": "nan"}
+
 	tests := []struct {
-		name   string
-		value  string
-		wanted bool
+		name         string
+		key          string
+		defaultValue float64
+		want         float64
 	}{
-		{name: "empty string", value: "", wanted: true},
-		{name: "non-empty string", value: "not empty", wanted: false},
-		{name: "whitespace string", value: "   ", wanted: true},
+		{name: "existing valid", key: "VALID", defaultValue: 0.5, want: 0.8},
+		{name: "existing invalid", key: "INVALID", defaultValue: 0.5, want: 0.5},
+		{name: "not a number", key: "NAN", defaultValue: 0.5, want: 0.5},
+		{name: "non-existent key", key: "NONEXISTENT", defaultValue: 0.5, want: 0.5},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := isEmpty(tc.value)
-			if got != tc.wanted {
-				t.Errorf("isEmpty(%q) = %v, want %v", tc.value, got, tc.wanted)
-			}
-			notSame := isNotEmpty(tc.value)
-			if notSame != !tc.wanted {
-				t.Errorf("isNotEmpty(%q) = %v, want %v", tc.value, notSame, !tc.wanted)
+			got := env.AsPercentOr(tc.key, tc.defaultValue)
+			if got != tc.want {
+				t.Errorf("AsPercentOr(%q, %v) = %v, want %v", tc.key, tc.defaultValue, got, tc.want)
 			}
 		})
 	}
 }
 
-func Test_contains(t *testing.T) {
-	tests := []struct {
-		name   string
+func TestAsPort(t *testing.T) {
+	env := Environment{
+		"VALID_PORT":    "8080",
+		"INVALID_PORT1": "invalid-port",
+		"INVALID_PORT2": "999999",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      int
+		wantPanic bool
+	}{
+		{name: "valid port", key: "VALID_PORT", want: 8080, wantPanic: false},
+		{name: "invalid port", key: "INVALID_PORT1", want: 0, wantPanic: true},
+		{name: "out of range port", key: "INVALID_PORT2", want: 0, wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: 0, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsPort did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsPort(tc.key)
+			if got != tc.want {
+				t.Errorf("AsPort(%q) = %d, want %d", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsPortOr(t *testing.T) {
+	env := Environment{
+		"VALID_PORT":   "8080",
+		"INVALID_PORT": "invalid-port",
+		"OUT_OF_RANGE": "999999",
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		defaultValue int
+		want         int
+	}{
+		{name: "existing valid", key: "VALID_PORT", defaultValue: 999, want: 8080},
+		{name: "existing invalid", key: "INVALID_PORT", defaultValue: 999, want: 999},
+		{name: "out of range", key: "OUT_OF_RANGE", defaultValue: 999, want: 999},
+		{name: "non-existent key", key: "NONEXISTENT", defaultValue: 999, want: 999},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := env.AsPortOr(tc.key, tc.defaultValue)
+			if got != tc.want {
+				t.Errorf("AsPortOr(%q, %v) = %v, want %v", tc.key, tc.defaultValue, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsIntInRange(t *testing.T) {
+	env := Environment{
+		"WORKERS":   "16",
+		"LOWER":     "1",
+		"UPPER":     "256",
+		"TOO_LOW":   "0",
+		"TOO_HIGH":  "257",
+		"NEGATIVE":  "-5",
+		"NOT_A_NUM": "many",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		min       int
+		max       int
+		want      int
+		wantPanic bool
+	}{
+		{name: "within range", key: "WORKERS", min: 1, max: 256, want: 16},
+		{name: "lower bound", key: "LOWER", min: 1, max: 256, want: 1},
+		{name: "upper bound", key: "UPPER", min: 1, max: 256, want: 256},
+		{name: "below range", key: "TOO_LOW", min: 1, max: 256, wantPanic: true},
+		{name: "above range", key: "TOO_HIGH", min: 1, max: 256, wantPanic: true},
+		{name: "negative range", key: "NEGATIVE", min: -10, max: -1, want: -5},
+		{name: "single value range", key: "WORKERS", min: 16, max: 16, want: 16},
+		{name: "inverted range", key: "WORKERS", min: 256, max: 1, wantPanic: true},
+		{name: "invalid value", key: "NOT_A_NUM", min: 1, max: 256, wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", min: 1, max: 256, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsIntInRange did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsIntInRange(tc.key, tc.min, tc.max)
+			if got != tc.want {
+				t.Errorf("AsIntInRange(%q, %d, %d) = %v, want %v", tc.key, tc.min, tc.max, got, tc.want)
+			}
+		})
+	}
+
+	got, err := RenderTemplate(`worker_processes {{ asIntInRange "WORKERS" 1 256 }};`, env)
+	if err != nil || got != "worker_processes 16;" {
+		t.Errorf("asIntInRange in template = %q, %v", got, err)
+	}
+	if _, err := RenderTemplate(`{{ asIntInRange "TOO_HIGH" 1 256 }}`, env); err == nil || !strings.Contains(err.Error(), "out of range (1-256)") {
+		t.Errorf("Expected range error but got %v", err)
+	}
+}
+
+func TestAsIntInRangeOr(t *testing.T) {
+	env := Environment{
+		"WORKERS":   "16",
+		"TOO_HIGH":  "257",
+		"NOT_A_NUM": "many",
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		defaultValue int
+		want         int
+		wantPanic    bool
+	}{
+		{name: "within range", key: "WORKERS", defaultValue: 4, want: 16},
+		{name: "out of range", key: "TOO_HIGH", defaultValue: 4, want: 4},
+		{name: "invalid value", key: "NOT_A_NUM", defaultValue: 4, want: 4},
+		{name: "non-existent key", key: "NONEXISTENT", defaultValue: 4, want: 4},
+		{name: "default out of range", key: "WORKERS", defaultValue: 0, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsIntInRangeOr did not panic for default %d", tc.defaultValue)
+					}
+				}()
+			}
+
+			got := env.AsIntInRangeOr(tc.key, 1, 256, tc.defaultValue)
+			if got != tc.want {
+				t.Errorf("AsIntInRangeOr(%q, 1, 256, %v) = %v, want %v", tc.key, tc.defaultValue, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsPath(t *testing.T) {
+	env := Environment{
+		"CLEAN":    "/etc/app/",
+		"DIRTY":    "/etc//app/../app/./conf",
+		"RELATIVE": "data/../logs",
+		"EMPTY":    "",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "trailing slash", key: "CLEAN", want: "/etc/app", wantPanic: false},
+		{name: "dirty path", key: "DIRTY", want: "/etc/app/conf", wantPanic: false},
+		{name: "relative path", key: "RELATIVE", want: "logs", wantPanic: false},
+		{name: "empty value", key: "EMPTY", want: "", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsPath did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsPath(tc.key)
+			if got != tc.want {
+				t.Errorf("AsPath(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsAbsPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+	env := Environment{"ABSOLUTE": "/etc/app/", "RELATIVE": "data/../logs"}
+
+	if got := env.AsAbsPath("ABSOLUTE"); got != "/etc/app" {
+		t.Errorf("AsAbsPath(%q) = %q, want %q", "ABSOLUTE", got, "/etc/app")
+	}
+	if got, want := env.AsAbsPath("RELATIVE"), filepath.Join(wd, "logs"); got != want {
+		t.Errorf("AsAbsPath(%q) = %q, want %q", "RELATIVE", got, want)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("AsAbsPath did not panic for missing key")
+		}
+	}()
+	env.AsAbsPath("NONEXISTENT")
+}
+
+func TestAsExistingPath(t *testing.T) {
+	dir := t.TempDir()
+	env := Environment{
+		"EXISTING": dir + "/",
+		"MISSING":  filepath.Join(dir, "missing"),
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "existing path", key: "EXISTING", want: dir, wantPanic: false},
+		{name: "missing path", key: "MISSING", want: "", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsExistingPath did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsExistingPath(tc.key)
+			if got != tc.want {
+				t.Errorf("AsExistingPath(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsUUID(t *testing.T) {
+	env := Environment{
+		"LOWER":      "123e4567-e89b-12d3-a456-426614174000",
+		"UPPER":      "123E4567-E89B-12D3-A456-426614174000",
+		"NO_DASHES":  "123e4567e89b12d3a456426614174000",
+		"BAD_DASHES": "123e4567-e89b12d3-a456-426614174000-",
+		"NOT_HEX":    "123e4567-e89b-12d3-a456-42661417400g",
+		"BRACES":     "{123e4567-e89b-12d3-a456-426614174000}",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "lower case", key: "LOWER", want: "123e4567-e89b-12d3-a456-426614174000", wantPanic: false},
+		{name: "upper case", key: "UPPER", want: "123e4567-e89b-12d3-a456-426614174000", wantPanic: false},
+		{name: "no dashes", key: "NO_DASHES", want: "", wantPanic: true},
+		{name: "misplaced dashes", key: "BAD_DASHES", want: "", wantPanic: true},
+		{name: "not hex", key: "NOT_HEX", want: "", wantPanic: true},
+		{name: "braces", key: "BRACES", want: "", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsUUID did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsUUID(tc.key)
+			if got != tc.want {
+				t.Errorf("AsUUID(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsUUIDOr(t *testing.T) {
+	env := Environment{
+		"VALID":   "123E4567-E89B-12D3-A456-426614174000",
+		"INVALID": "not-a-uuid",
+	}
+	defaultValue := "00000000-0000-0000-0000-000000000000"
+
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "existing valid", key: "VALID", want: "123e4567-e89b-12d3-a456-426614174000"},
+		{name: "existing invalid", key: "INVALID", want: defaultValue},
+		{name: "non-existent key", key: "NONEXISTENT", want: defaultValue},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := env.AsUUIDOr(tc.key, defaultValue)
+			if got != tc.want {
+				t.Errorf("AsUUIDOr(%q, %q) = %q, want %q", tc.key, defaultValue, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsSemver(t *testing.T) {
+	env := Environment{
+		"PLAIN":      "1.4.2",
+		"PREFIXED":   "v2.0.0-rc.1+build.5",
+		"SHORT":      "1.4",
+		"INVALID":    "latest",
+		"DOUBLE_V":   "vv1.0.0",
+		"LEADING_0S": "01.2.3",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "plain", key: "PLAIN", want: "1.4.2", wantPanic: false},
+		{name: "v prefix with pre-release", key: "PREFIXED", want: "2.0.0-rc.1+build.5", wantPanic: false},
+		{name: "missing patch", key: "SHORT", want: "", wantPanic: true},
+		{name: "not a version", key: "INVALID", want: "", wantPanic: true},
+		{name: "double v prefix", key: "DOUBLE_V", want: "", wantPanic: true},
+		{name: "leading zeros", key: "LEADING_0S", want: "", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsSemver did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsSemver(tc.key)
+			if got != tc.want {
+				t.Errorf("AsSemver(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsColor(t *testing.T) {
+	env := Environment{
+		"FULL":       "#1a2b3c",
+		"UPPER":      "#1A2B3C",
+		"NO_HASH":    "1a2b3c",
+		"SHORTHAND":  "#abc",
+		"SHORT_BARE": "F0A",
+		"FOUR":       "#abcd",
+		"NOT_HEX":    "#ggg",
+		"NAMED":      "red",
+		"DOUBLE":     "##abc",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "six digits", key: "FULL", want: "#1a2b3c", wantPanic: false},
+		{name: "upper case", key: "UPPER", want: "#1a2b3c", wantPanic: false},
+		{name: "without hash", key: "NO_HASH", want: "#1a2b3c", wantPanic: false},
+		{name: "shorthand", key: "SHORTHAND", want: "#aabbcc", wantPanic: false},
+		{name: "shorthand without hash", key: "SHORT_BARE", want: "#ff00aa", wantPanic: false},
+		{name: "four digits", key: "FOUR", want: "", wantPanic: true},
+		{name: "not hex", key: "NOT_HEX", want: "", wantPanic: true},
+		{name: "named color", key: "NAMED", want: "", wantPanic: true},
+		{name: "double hash", key: "DOUBLE", want: "", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsColor did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsColor(tc.key)
+			if got != tc.want {
+				t.Errorf("AsColor(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsColorOr(t *testing.T) {
+	env := Environment{"VALID": "#ABC", "INVALID": "blue"}
+
+	tests := []struct {
+		name         string
+		key          string
+		defaultValue string
+		want         string
+	}{
+		{name: "existing valid", key: "VALID", defaultValue: "#000000", want: "#aabbcc"},
+		{name: "existing invalid", key: "INVALID", defaultValue: "#000000", want: "#000000"},
+		{name: "non-existent key", key: "NONEXISTENT", defaultValue: "#000000", want: "#000000"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := env.AsColorOr(tc.key, tc.defaultValue)
+			if got != tc.want {
+				t.Errorf("AsColorOr(%q, %q) = %q, want %q", tc.key, tc.defaultValue, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsTimeZone(t *testing.T) {
+	env := Environment{
+		"NEW_YORK": "America/New_York",
+		"UTC":      "UTC",
+		"INVALID":  "Mars/Phobos",
+		"EMPTY":    "",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "new york", key: "NEW_YORK", want: "America/New_York", wantPanic: false},
+		{name: "utc", key: "UTC", want: "UTC", wantPanic: false},
+		{name: "invalid zone", key: "INVALID", want: "", wantPanic: true},
+		{name: "empty value", key: "EMPTY", want: "", wantPanic: true},
+		{name: "non-existent key", key: "NONEXISTENT", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsTimeZone did not panic for key %s", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsTimeZone(tc.key)
+			if got != tc.want {
+				t.Errorf("AsTimeZone(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsTimeZoneOr(t *testing.T) {
+	env := Environment{
+		"NEW_YORK": "America/New_York",
+		"INVALID":  "Mars/Phobos",
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		defaultValue string
+		want         string
+	}{
+		{name: "existing valid", key: "NEW_YORK", defaultValue: "UTC", want: "America/New_York"},
+		{name: "existing invalid", key: "INVALID", defaultValue: "UTC", want: "UTC"},
+		{name: "non-existent key", key: "NONEXISTENT", defaultValue: "UTC", want: "UTC"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := env.AsTimeZoneOr(tc.key, tc.defaultValue)
+			if got != tc.want {
+				t.Errorf("AsTimeZoneOr(%q, %q) = %q, want %q", tc.key, tc.defaultValue, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEnvironment_AsStringOrFile(t *testing.T) {
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(certPath, []byte("-----BEGIN CERTIFICATE-----\n"), 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	env := Environment{
+		"TLS_CERT":    "@" + certPath,
+		"TOKEN":       "inline",
+		"HANDLE":      "@@zep",
+		"MISSING_REF": "@" + filepath.Join(t.TempDir(), "missing.pem"),
+		"EMPTY":       "",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantPanic bool
+	}{
+		{name: "file reference", key: "TLS_CERT", want: "-----BEGIN CERTIFICATE-----\n"},
+		{name: "inline value", key: "TOKEN", want: "inline"},
+		{name: "escaped at", key: "HANDLE", want: "@zep"},
+		{name: "empty value", key: "EMPTY", want: ""},
+		{name: "missing file", key: "MISSING_REF", wantPanic: true},
+		{name: "missing key", key: "NOT_SET", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("AsStringOrFile did not panic for key %q", tc.key)
+					}
+				}()
+			}
+
+			got := env.AsStringOrFile(tc.key)
+			if got != tc.want {
+				t.Errorf("AsStringOrFile(%q) = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	dir := t.TempDir()
+	secrets := map[string]string{
+		"db_password": "s3cret\n",
+		"crlf":        "s3cret\r\n",
+		"multi":       "line1\nline2\n\n",
+		"raw":         "s3cret",
+	}
+	for name, content := range secrets {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("failed to create secret: %v", err)
+		}
+	}
+	env := Environment{"ZEP_SECRETS_DIR": dir}
+
+	tests := []struct {
+		name      string
+		secret    string
+		want      string
+		wantPanic bool
+	}{
+		{name: "trailing newline", secret: "db_password", want: "s3cret", wantPanic: false},
+		{name: "trailing crlf", secret: "crlf", want: "s3cret", wantPanic: false},
+		{name: "single newline only", secret: "multi", want: "line1\nline2\n", wantPanic: false},
+		{name: "no newline", secret: "raw", want: "s3cret", wantPanic: false},
+		{name: "missing secret", secret: "missing", want: "", wantPanic: true},
+		{name: "path traversal", secret: "../etc/passwd", want: "", wantPanic: true},
+		{name: "empty name", secret: "", want: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("ReadSecret did not panic for secret %q", tc.secret)
+					}
+				}()
+			}
+
+			got := env.ReadSecret(tc.secret)
+			if got != tc.want {
+				t.Errorf("ReadSecret(%q) = %q, want %q", tc.secret, got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_semverCompare(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		version    string
+		wanted     bool
+		wantPanic  bool
+	}{
+		{name: "greater or equal", constraint: ">=1.4.0", version: "1.4.2", wanted: true, wantPanic: false},
+		{name: "lower", constraint: ">=1.4.0", version: "1.3.9", wanted: false, wantPanic: false},
+		{name: "v prefix", constraint: ">=1.4.0", version: "v1.5.0", wanted: true, wantPanic: false},
+		{name: "tilde range", constraint: "~1.2", version: "1.3.0", wanted: false, wantPanic: false},
+		{name: "invalid constraint", constraint: ">>1", version: "1.0.0", wanted: false, wantPanic: true},
+		{name: "invalid version", constraint: ">=1.0.0", version: "latest", wanted: false, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("semverCompare did not panic for %q %q", tc.constraint, tc.version)
+					}
+				}()
+			}
+
+			got := semverCompare(tc.constraint, tc.version)
+			if got != tc.wanted {
+				t.Errorf("semverCompare(%q, %q) = %v, want %v", tc.constraint, tc.version, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"APP_VERSION": "v1.4.0"}
+	got, err := RenderTemplate(`{{ if semverCompare ">=1.4.0" (asSemver "APP_VERSION") }}on{{ else }}off{{ end }}`, env)
+	if err != nil || got != "on" {
+		t.Errorf("semverCompare in template = %q, %v, want %q", got, err, "on")
+	}
+}
+
+func Test_isEmpty(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted bool
+	}{
+		{name: "empty string", value: "", wanted: true},
+		{name: "whitespace only string", value: " \t", wanted: true},
+		{name: "non-empty string", value: "not empty", wanted: false},
+		{name: "whitespace string", value: "   ", wanted: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := isEmpty(tc.value)
+			if got != tc.wanted {
+				t.Errorf("isEmpty(%q) = %v, want %v", tc.value, got, tc.wanted)
+			}
+			notSame := isNotEmpty(tc.value)
+			if notSame != !tc.wanted {
+				t.Errorf("isNotEmpty(%q) = %v, want %v", tc.value, notSame, !tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_isValid(t *testing.T) {
+	tests := []struct {
+		value                               string
+		isInt, isFloat, isURL, isPort, isIP bool
+	}{
+		{value: "8080", isInt: true, isFloat: true, isPort: true},
+		{value: "0", isInt: true, isFloat: true},
+		{value: "70000", isInt: true, isFloat: true},
+		{value: "-1", isInt: true, isFloat: true},
+		{value: "1.5", isFloat: true},
+		{value: "https://example.com/path", isURL: true},
+		{value: "example.com", isURL: false},
+		{value: "10.0.0.1", isIP: true},
+		{value: "::ffff:1.2.3.4", isIP: true},
+		{value: "", isURL: false},
+		{value: "not a value"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			for _, check := range []struct {
+				name   string
+				fn     func(string) bool
+				wanted bool
+			}{
+				{name: "isInt", fn: isInt, wanted: tc.isInt},
+				{name: "isFloat", fn: isFloat, wanted: tc.isFloat},
+				{name: "isURL", fn: isURL, wanted: tc.isURL},
+				{name: "isPort", fn: isPort, wanted: tc.isPort},
+				{name: "isIP", fn: isIP, wanted: tc.isIP},
+			} {
+				if got := check.fn(tc.value); got != check.wanted {
+					t.Errorf("%s(%q) = %v, want %v", check.name, tc.value, got, check.wanted)
+				}
+			}
+		})
+	}
+
+	env := Environment{"PORT": "http"}
+	got, err := RenderTemplate(`listen {{ if isPort (asString "PORT") }}{{ asPort "PORT" }}{{ else }}80{{ end }};`, env)
+	if err != nil || got != "listen 80;" {
+		t.Errorf("isPort in template = %q, %v", got, err)
+	}
+}
+
+func Test_contains(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		substr string
+		wanted bool
+	}{
+		{name: "substring present", value: "hello world", substr: "world", wanted: true},
+		{name: "substring not present", value: "hello world", substr: "foo", wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := contains(tc.value, tc.substr)
+			if got != tc.wanted {
+				t.Errorf("contains(%q, %q) = %v, want %v", tc.value, tc.substr, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_containsCaseInsensitive(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		substr string
+		wanted bool
+	}{
+		{name: "substring present", value: "hello world", substr: "WORLD", wanted: true},
+		{name: "substring not present", value: "hello world", substr: "foo", wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := containsCaseInsensitive(tc.value, tc.substr)
+			if got != tc.wanted {
+				t.Errorf("containsCaseInsensitive(%q, %q) = %v, want %v", tc.value, tc.substr, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_containsAny(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		chars  string
+		wanted bool
+	}{
+		{name: "substring present", value: "hello world", chars: "w", wanted: true},
+		{name: "substring not present", value: "hello world", chars: "z.u", wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := containsAny(tc.value, tc.chars)
+			if got != tc.wanted {
+				t.Errorf("containsAny(%q, %q) = %v, want %v", tc.value, tc.chars, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_hasPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		prefix string
+		wanted bool
+	}{
+		{name: "prefix present", value: "hello world", prefix: "hello", wanted: true},
+		{name: "prefix not present", value: "hello world", prefix: "world", wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := hasPrefix(tc.value, tc.prefix)
+			if got != tc.wanted {
+				t.Errorf("hasPrefix(%q, %q) = %v, want %v", tc.value, tc.prefix, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_hasSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		suffix string
+		wanted bool
+	}{
+		{name: "suffix present", value: "hello world", suffix: "world", wanted: true},
+		{name: "suffix not present", value: "hello world", suffix: "hello", wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := hasSuffix(tc.value, tc.suffix)
+			if got != tc.wanted {
+				t.Errorf("hasSuffix(%q, %q) = %v, want %v", tc.value, tc.suffix, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_toLower(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "lowercase", value: "hello", wanted: "hello"},
+		{name: "uppercase", value: "HELLO", wanted: "hello"},
+		{name: "mixed case", value: "HeLLo", wanted: "hello"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := toLower(tc.value)
+			if got != tc.wanted {
+				t.Errorf("toLower(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_toUpper(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "lowercase", value: "hello", wanted: "HELLO"},
+		{name: "uppercase", value: "HELLO", wanted: "HELLO"},
+		{name: "mixed case", value: "HeLLo", wanted: "HELLO"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := toUpper(tc.value)
+			if got != tc.wanted {
+				t.Errorf("toUpper(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_title(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "single word", value: "hello", wanted: "Hello"},
+		{name: "multiple words", value: "hello big  world", wanted: "Hello Big  World"},
+		{name: "keeps inner case", value: "my aPP", wanted: "My APP"},
+		{name: "unicode", value: "élan über", wanted: "Élan Über"},
+		{name: "digraph", value: "ǆemal ǉubljana", wanted: "ǅemal ǈubljana"},
+		{name: "empty string", value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := title(tc.value)
+			if got != tc.wanted {
+				t.Errorf("title(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_capitalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "single word", value: "hello", wanted: "Hello"},
+		{name: "multiple words", value: "hello world", wanted: "Hello world"},
+		{name: "unicode", value: "éclair", wanted: "Éclair"},
+		{name: "digraph", value: "ǆemal", wanted: "ǅemal"},
+		{name: "empty string", value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := capitalize(tc.value)
+			if got != tc.wanted {
+				t.Errorf("capitalize(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_repeat(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		value     string
+		wanted    string
+		wantPanic bool
+	}{
+		{name: "separator", count: 5, value: "-", wanted: "-----", wantPanic: false},
+		{name: "multi-rune", count: 3, value: "═╬", wanted: "═╬═╬═╬", wantPanic: false},
+		{name: "zero count", count: 0, value: "-", wanted: "", wantPanic: false},
+		{name: "negative count", count: -1, value: "-", wanted: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("repeat did not panic for count %d", tc.count)
+					}
+				}()
+			}
+
+			got := repeat(tc.count, tc.value)
+			if got != tc.wanted {
+				t.Errorf("repeat(%d, %q) = %q, want %q", tc.count, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_padLeft(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		pad    string
+		value  string
+		wanted string
+	}{
+		{name: "single rune pad", width: 5, pad: "0", value: "42", wanted: "00042"},
+		{name: "multi-rune pad", width: 6, pad: "ab", value: "x", wanted: "ababax"},
+		{name: "unicode pad", width: 4, pad: "·", value: "é", wanted: "···é"},
+		{name: "at width", width: 2, pad: "0", value: "42", wanted: "42"},
+		{name: "over width", width: 1, pad: "0", value: "42", wanted: "42"},
+		{name: "empty pad", width: 5, pad: "", value: "42", wanted: "42"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := padLeft(tc.width, tc.pad, tc.value)
+			if got != tc.wanted {
+				t.Errorf("padLeft(%d, %q, %q) = %q, want %q", tc.width, tc.pad, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_padNum(t *testing.T) {
+	tests := []struct {
+		width  int
+		n      int
+		wanted string
+	}{
+		{width: 3, n: 7, wanted: "007"},
+		{width: 3, n: 42, wanted: "042"},
+		{width: 3, n: 1234, wanted: "1234"},
+		{width: 4, n: -7, wanted: "-007"},
+		{width: 0, n: 5, wanted: "5"},
+		{width: 2, n: 0, wanted: "00"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.wanted, func(t *testing.T) {
+			got := padNum(tc.width, tc.n)
+			if got != tc.wanted {
+				t.Errorf("padNum(%d, %d) = %q, want %q", tc.width, tc.n, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"ID": "42", "NAME": "web"}
+	got, err := RenderTemplate(`{{ asInt "ID" | padNum 5 }} {{ format "%s-%03d" (asString "NAME") 7 }}`, env)
+	if err != nil || got != "00042 web-007" {
+		t.Errorf("padNum and format in template = %q, %v, want %q", got, err, "00042 web-007")
+	}
+}
+
+func Test_sprintfStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		args   []any
+		wanted string
+		panics bool
+	}{
+		{name: "matching verbs", format: "%s:%d", args: []any{"host", 80}, wanted: "host:80"},
+		{name: "flags and width", format: "%-5s|%05.1f|%x", args: []any{"ab", 3.14159, 255}, wanted: "ab   |003.1|ff"},
+		{name: "v accepts anything", format: "%v %v", args: []any{[]string{"a"}, map[string]int{"b": 1}}, wanted: "[a] map[b:1]"},
+		{name: "type verb", format: "%T", args: []any{1}, wanted: "int"},
+		{name: "literal percent", format: "100%% %s", args: []any{"done"}, wanted: "100% done"},
+		{name: "literal percent bang", format: "%%!%s", args: []any{"x"}, wanted: "%!x"},
+		{name: "argument containing marker", format: "%s", args: []any{"%!d(string=x)"}, wanted: "%!d(string=x)"},
+		{name: "no arguments", format: "plain", args: nil, wanted: "plain"},
+		{name: "wrong type", format: "%d", args: []any{"80"}, panics: true},
+		{name: "wrong type in slice", format: "%d", args: []any{[]string{"a"}}, panics: true},
+		{name: "missing argument", format: "%s:%d", args: []any{"host"}, panics: true},
+		{name: "extra argument", format: "%s", args: []any{"a", "b"}, panics: true},
+		{name: "star width", format: "%*d", args: []any{5, 42}, wanted: "   42"},
+		{name: "star precision", format: "%.*f", args: []any{2, 3.14159}, wanted: "3.14"},
+		{name: "int with string verb", format: "%s", args: []any{5}, panics: true},
+		{name: "pointer with string verb", format: "%s", args: []any{new(int)}, panics: true},
+		{name: "bad width", format: "%*d", args: []any{"x", 1}, panics: true},
+		{name: "bad precision", format: "%.*f", args: []any{"x", 1.0}, panics: true},
+		{name: "no verb", format: "100%", args: nil, panics: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("sprintfStrict(%q) panic = %v, want panic %v", tc.format, r, tc.panics)
+				}
+			}()
+			got := sprintfStrict(tc.format, tc.args...)
+			if got != tc.wanted {
+				t.Errorf("sprintfStrict(%q) = %q, want %q", tc.format, got, tc.wanted)
+			}
+		})
+	}
+
+	ptr := new(int)
+	if got, want := sprintfStrict("%p", ptr), fmt.Sprintf("%p", ptr); got != want {
+		t.Errorf("sprintfStrict(%%p) = %q, want %q", got, want)
+	}
+
+	_, err := RenderTemplate(`listen {{ sprintfStrict "%d" (asString "PORT") }};`, Environment{"PORT": "80"})
+	if err == nil {
+		t.Errorf("expected template error for mismatched verb")
+	}
+}
+
+func Test_nospace(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "internal spaces", value: "My Service Name", wanted: "MyServiceName"},
+		{name: "tabs and newlines", value: " a\tb\nc\r\n", wanted: "abc"},
+		{name: "unicode spaces", value: "a\u00a0b\u2003c", wanted: "abc"},
+		{name: "no whitespace", value: "abc", wanted: "abc"},
+		{name: "only whitespace", value: " \t ", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nospace(tc.value)
+			if got != tc.wanted {
+				t.Errorf("nospace(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_reverse(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "ascii", value: "abc", wanted: "cba"},
+		{name: "multibyte", value: "日本語", wanted: "語本日"},
+		{name: "mixed", value: "añb€", wanted: "€bña"},
+		{name: "emoji", value: "a😀b", wanted: "b😀a"},
+		{name: "domain labels", value: "com.example", wanted: "elpmaxe.moc"},
+		{name: "empty", value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := reverse(tc.value); got != tc.wanted {
+				t.Errorf("reverse(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_truncBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		value  string
+		wanted string
+	}{
+		{name: "ascii", n: 3, value: "abcdef", wanted: "abc"},
+		{name: "shorter than limit", n: 10, value: "abc", wanted: "abc"},
+		{name: "exact limit", n: 3, value: "abc", wanted: "abc"},
+		{name: "two byte rune on boundary", n: 2, value: "aéb", wanted: "a"},
+		{name: "two byte rune fits", n: 3, value: "aéb", wanted: "aé"},
+		{name: "emoji split", n: 5, value: "ab😀cd", wanted: "ab"},
+		{name: "emoji fits", n: 6, value: "ab😀cd", wanted: "ab😀"},
+		{name: "cjk", n: 7, value: "日本語", wanted: "日本"},
+		{name: "zero", n: 0, value: "abc", wanted: ""},
+		{name: "negative", n: -1, value: "abc", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := truncBytes(tc.n, tc.value)
+			if got != tc.wanted {
+				t.Errorf("truncBytes(%d, %q) = %q, want %q", tc.n, tc.value, got, tc.wanted)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncBytes(%d, %q) = %q is not valid UTF-8", tc.n, tc.value, got)
+			}
+		})
+	}
+
+	value := "a😀é日b"
+	for n := 0; n <= len(value)+1; n++ {
+		got := truncBytes(n, value)
+		if len(got) > n || !utf8.ValidString(got) || !strings.HasPrefix(value, got) {
+			t.Errorf("truncBytes(%d, %q) = %q is not a valid prefix within the limit", n, value, got)
+		}
+	}
+}
+
+func Test_wrap(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		value  string
+		wanted string
+	}{
+		{name: "short", width: 20, value: "hello world", wanted: "hello world"},
+		{name: "exact width", width: 11, value: "hello world", wanted: "hello world"},
+		{name: "wrapped", width: 10, value: "the quick brown fox jumps", wanted: "the quick\nbrown fox\njumps"},
+		{name: "narrow", width: 1, value: "a bc d", wanted: "a\nbc\nd"},
+		{name: "long word not broken", width: 5, value: "a supercalifragilistic b", wanted: "a\nsupercalifragilistic\nb"},
+		{name: "paragraphs kept", width: 7, value: "one two three\n\nfour five", wanted: "one two\nthree\n\nfour\nfive"},
+		{name: "whitespace collapsed", width: 20, value: "  a   b\tc  ", wanted: "a b c"},
+		{name: "multibyte", width: 5, value: "äöü äöü", wanted: "äöü\näöü"},
+		{name: "zero width", width: 0, value: "a b", wanted: "a\nb"},
+		{name: "empty", width: 10, value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := wrap(tc.width, tc.value)
+			if got != tc.wanted {
+				t.Errorf("wrap(%d, %q) = %q, want %q", tc.width, tc.value, got, tc.wanted)
+			}
+		})
+	}
+
+	got := wrapWith(10, "\n# ", "the quick brown fox\njumps")
+	if got != "the quick\n# brown fox\n# jumps" {
+		t.Errorf("wrapWith = %q, want %q", got, "the quick\n# brown fox\n# jumps")
+	}
+}
+
+func Test_padRight(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		pad    string
+		value  string
+		wanted string
+	}{
+		{name: "single rune pad", width: 5, pad: " ", value: "ab", wanted: "ab   "},
+		{name: "multi-rune pad", width: 6, pad: ".-", value: "x", wanted: "x.-.-."},
+		{name: "unicode pad", width: 4, pad: "·", value: "é", wanted: "é···"},
+		{name: "at width", width: 2, pad: " ", value: "ab", wanted: "ab"},
+		{name: "over width", width: 1, pad: " ", value: "ab", wanted: "ab"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := padRight(tc.width, tc.pad, tc.value)
+			if got != tc.wanted {
+				t.Errorf("padRight(%d, %q, %q) = %q, want %q", tc.width, tc.pad, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_substr(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  int
+		end    int
+		value  string
+		wanted string
+	}{
+		{name: "prefix", start: 0, end: 7, value: "0123456789abcdef", wanted: "0123456"},
+		{name: "middle", start: 2, end: 4, value: "hello", wanted: "ll"},
+		{name: "negative start", start: -3, end: 2, value: "hello", wanted: "he"},
+		{name: "end out of range", start: 3, end: 100, value: "hello", wanted: "lo"},
+		{name: "negative end", start: 1, end: -1, value: "hello", wanted: "ello"},
+		{name: "start after end", start: 4, end: 2, value: "hello", wanted: ""},
+		{name: "multibyte", start: 1, end: 3, value: "héllo", wanted: "él"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := substr(tc.start, tc.end, tc.value)
+			if got != tc.wanted {
+				t.Errorf("substr(%d, %d, %q) = %q, want %q", tc.start, tc.end, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trunc(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		value  string
+		wanted string
+	}{
+		{name: "first runes", n: 3, value: "hello", wanted: "hel"},
+		{name: "last runes", n: -3, value: "hello", wanted: "llo"},
+		{name: "longer than string", n: 10, value: "hello", wanted: "hello"},
+		{name: "negative longer than string", n: -10, value: "hello", wanted: "hello"},
+		{name: "zero", n: 0, value: "hello", wanted: ""},
+		{name: "multibyte", n: 2, value: "日本語", wanted: "日本"},
+		{name: "multibyte last", n: -1, value: "日本語", wanted: "語"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trunc(tc.n, tc.value)
+			if got != tc.wanted {
+				t.Errorf("trunc(%d, %q) = %q, want %q", tc.n, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trim(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		cutset string
+		wanted string
+	}{
+		{name: "trim spaces", value: "  hello  ", cutset: " ", wanted: "hello"},
+		{name: "trim asterisks", value: "***hello***", cutset: "*", wanted: "hello"},
+		{name: "trim mixed", value: "  ***hello***  ", cutset: " *", wanted: "hello"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trim(tc.value, tc.cutset)
+			if got != tc.wanted {
+				t.Errorf("trim(%q, %q) = %q, want %q", tc.value, tc.cutset, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trimLeft(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		cutset string
+		wanted string
+	}{
+		{name: "trim spaces", value: "  hello  ", cutset: " ", wanted: "hello  "},
+		{name: "trim asterisks", value: "***hello***", cutset: "*", wanted: "hello***"},
+		{name: "trim mixed", value: "  ***hello***  ", cutset: " *", wanted: "hello***  "},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trimLeft(tc.value, tc.cutset)
+			if got != tc.wanted {
+				t.Errorf("trimLeft(%q, %q) = %q, want %q", tc.value, tc.cutset, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trimRight(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		cutset string
+		wanted string
+	}{
+		{name: "trim spaces", value: "  hello  ", cutset: " ", wanted: "  hello"},
+		{name: "trim asterisks", value: "***hello***", cutset: "*", wanted: "***hello"},
+		{name: "trim mixed", value: "  ***hello***  ", cutset: " *", wanted: "  ***hello"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trimRight(tc.value, tc.cutset)
+			if got != tc.wanted {
+				t.Errorf("trimRight(%q, %q) = %q, want %q", tc.value, tc.cutset, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trimSpace(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "trim spaces", value: "  hello  ", wanted: "hello"},
+		{name: "trim asterisks", value: "***hello***", wanted: "***hello***"},
+		{name: "trim mixed", value: "  ***hello***  ", wanted: "***hello***"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trimSpace(tc.value)
+			if got != tc.wanted {
+				t.Errorf("trimSpace(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trimPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		value  string
+		wanted string
+	}{
+		{name: "protocol", prefix: "https://", value: "https://example.com", wanted: "example.com"},
+		{name: "prefix not present", prefix: "http://", value: "https://example.com", wanted: "https://example.com"},
+		{name: "only once", prefix: "ab", value: "ababc", wanted: "abc"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trimPrefix(tc.prefix, tc.value)
+			if got != tc.wanted {
+				t.Errorf("trimPrefix(%q, %q) = %q, want %q", tc.prefix, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_trimSuffix(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		value  string
+		wanted string
+	}{
+		{name: "extension", suffix: ".tmpl", value: "nginx.conf.tmpl", wanted: "nginx.conf"},
+		{name: "suffix not present", suffix: ".tmpl", value: "nginx.conf", wanted: "nginx.conf"},
+		{name: "only once", suffix: "bc", value: "abcbc", wanted: "abc"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := trimSuffix(tc.suffix, tc.value)
+			if got != tc.wanted {
+				t.Errorf("trimSuffix(%q, %q) = %q, want %q", tc.suffix, tc.value, got, tc.wanted)
+			}
+		})
+	}
+
+	got, err := RenderTemplate(`{{ "nginx.conf.tmpl" | trimSuffix ".tmpl" }}`, Environment{})
+	if err != nil || got != "nginx.conf" {
+		t.Errorf("trimSuffix in pipeline = %q, %v, want %q", got, err, "nginx.conf")
+	}
+}
+
+func Test_quote(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "simple string", value: "hello", wanted: `"hello"`},
+		{name: "embedded quotes", value: `say "hi"`, wanted: `"say \"hi\""`},
+		{name: "newline", value: "a\nb", wanted: `"a\nb"`},
+		{name: "empty string", value: "", wanted: `""`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := quote(tc.value)
+			if got != tc.wanted {
+				t.Errorf("quote(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_squote(t *testing.T) {
+	tests := []struct {
+		name   string
 		value  string
-		substr string
+		wanted string
+	}{
+		{name: "simple string", value: "hello", wanted: `'hello'`},
+		{name: "embedded single quotes", value: "it's", wanted: `'it''s'`},
+		{name: "embedded double quotes", value: `say "hi"`, wanted: `'say "hi"'`},
+		{name: "newline", value: "a\nb", wanted: "'a\nb'"},
+		{name: "empty string", value: "", wanted: `''`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := squote(tc.value)
+			if got != tc.wanted {
+				t.Errorf("squote(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_shellQuote(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "empty string", value: "", wanted: `''`},
+		{name: "spaces", value: "hello big world", wanted: `'hello big world'`},
+		{name: "single quotes", value: "it's", wanted: `'it'\''s'`},
+		{name: "dollar sign", value: "$(rm -rf /) $HOME", wanted: `'$(rm -rf /) $HOME'`},
+		{name: "single quote and dollar", value: "'$x'", wanted: `''\''$x'\'''`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := shellQuote(tc.value)
+			if got != tc.wanted {
+				t.Errorf("shellQuote(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_htmlEscape(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		escaped string
+	}{
+		{name: "tags", value: "<b>bold</b>", escaped: "&lt;b&gt;bold&lt;/b&gt;"},
+		{name: "ampersand", value: "a & b", escaped: "a &amp; b"},
+		{name: "quotes", value: `"double" 'single'`, escaped: "&#34;double&#34; &#39;single&#39;"},
+		{name: "plain", value: "hello", escaped: "hello"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := htmlEscape(tc.value)
+			if got != tc.escaped {
+				t.Errorf("htmlEscape(%q) = %q, want %q", tc.value, got, tc.escaped)
+			}
+			back := htmlUnescape(got)
+			if back != tc.value {
+				t.Errorf("htmlUnescape(%q) = %q, want %q", got, back, tc.value)
+			}
+		})
+	}
+
+	if got := htmlUnescape("&quot;x&quot; &lt; &#x79;"); got != `"x" < y` {
+		t.Errorf("htmlUnescape named entities = %q, want %q", got, `"x" < y`)
+	}
+}
+
+func Test_mask(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "secret", value: "abcdefyz", wanted: "ab****yz"},
+		{name: "five characters", value: "abcde", wanted: "ab*de"},
+		{name: "four characters", value: "abcd", wanted: "****"},
+		{name: "three characters", value: "abc", wanted: "***"},
+		{name: "single character", value: "a", wanted: "*"},
+		{name: "empty string", value: "", wanted: ""},
+		{name: "multibyte", value: "пароль", wanted: "па**ль"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := mask(tc.value)
+			if got != tc.wanted {
+				t.Errorf("mask(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_base64Encode(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "simple string", value: "hello", wanted: "aGVsbG8="},
+		{name: "empty string", value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := base64Encode(tc.value)
+			if got != tc.wanted {
+				t.Errorf("base64Encode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_base64Decode(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wanted    string
+		wantPanic bool
+	}{
+		{name: "valid base64", value: "aGVsbG8=", wanted: "hello", wantPanic: false},
+		{name: "invalid base64", value: "invalid-base64", wanted: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("base64Decode did not panic for value %s", tc.value)
+					}
+				}()
+			}
+
+			got := base64Decode(tc.value)
+			if got != tc.wanted {
+				t.Errorf("base64Decode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_base64URLEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "simple string", value: "hello", wanted: "aGVsbG8"},
+		{name: "url-safe alphabet", value: "\xfb\xff\xbf", wanted: "-_-_"},
+		{name: "jwt header", value: `{"alg":"HS256","typ":"JWT"}`, wanted: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9"},
+		{name: "empty string", value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := base64URLEncode(tc.value)
+			if got != tc.wanted {
+				t.Errorf("base64URLEncode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+			if strings.ContainsAny(got, "+/=") {
+				t.Errorf("base64URLEncode(%q) = %q contains +, / or =", tc.value, got)
+			}
+			if decoded := base64URLDecode(got); decoded != tc.value {
+				t.Errorf("base64URLDecode(%q) = %q, want %q", got, decoded, tc.value)
+			}
+		})
+	}
+
+	// every byte value, so all 64 characters of the alphabet are produced
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	if got := base64URLEncode(string(all)); strings.ContainsAny(got, "+/=") {
+		t.Errorf("base64URLEncode of all bytes = %q contains +, / or =", got)
+	}
+}
+
+func Test_base64URLDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wanted    string
+		wantPanic bool
+	}{
+		{name: "valid", value: "aGVsbG8", wanted: "hello", wantPanic: false},
+		{name: "url-safe alphabet", value: "-_-_", wanted: "\xfb\xff\xbf", wantPanic: false},
+		{name: "padded", value: "aGVsbG8=", wanted: "", wantPanic: true},
+		{name: "standard alphabet", value: "+/+/", wanted: "", wantPanic: true},
+		{name: "invalid", value: "a", wanted: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("base64URLDecode did not panic for value %s", tc.value)
+					}
+				}()
+			}
+
+			got := base64URLDecode(tc.value)
+			if got != tc.wanted {
+				t.Errorf("base64URLDecode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_base32Encode(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wanted string
+	}{
+		{name: "simple string", value: "hello", wanted: "NBSWY3DP"},
+		{name: "padded", value: "hi", wanted: "NBUQ===="},
+		{name: "empty string", value: "", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := base32Encode(tc.value)
+			if got != tc.wanted {
+				t.Errorf("base32Encode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_base32Decode(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wanted    string
+		wantPanic bool
+	}{
+		{name: "valid base32", value: "NBSWY3DP", wanted: "hello", wantPanic: false},
+		{name: "padded", value: "NBUQ====", wanted: "hi", wantPanic: false},
+		{name: "unpadded", value: "NBUQ", wanted: "hi", wantPanic: false},
+		{name: "invalid characters", value: "nbswy3dp!", wanted: "", wantPanic: true},
+		{name: "padding inside", value: "NB=UQ", wanted: "", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("base32Decode did not panic for value %s", tc.value)
+					}
+				}()
+			}
+
+			got := base32Decode(tc.value)
+			if got != tc.wanted {
+				t.Errorf("base32Decode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_gzip(t *testing.T) {
+	for _, value := range []string{"hello world", "", strings.Repeat("payload ", 1000)} {
+		encoded := gzipEncode(value)
+		if got := gzipDecode(encoded); got != value {
+			t.Errorf("gzipDecode(gzipEncode(%q)) = %q", trunc(20, value), trunc(20, got))
+		}
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "invalid base64", value: "not base64!"},
+		{name: "not gzip data", value: base64Encode("plain text")},
+		{name: "truncated gzip data", value: base64Encode(base64Decode(gzipEncode("hello world"))[:15])},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("gzipDecode did not panic for value %q", tc.value)
+				}
+			}()
+			gzipDecode(tc.value)
+		})
+	}
+}
+
+func Test_hash(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		algorithm string // md5, sha1, sha224, sha256, sha512 otherwise panic
+		wantPanic bool
+	}{
+		{name: "valid md5", input: "hello", algorithm: "md5", wantPanic: false},
+		{name: "valid sha1", input: "hello", algorithm: "sha1", wantPanic: false},
+		{name: "valid sha224", input: "hello", algorithm: "sha224", wantPanic: false},
+		{name: "valid sha256", input: "hello", algorithm: "sha256", wantPanic: false},
+		{name: "valid sha512", input: "hello", algorithm: "sha512", wantPanic: false},
+		{name: "invalid algorithm", input: "hello", algorithm: "invalid", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("hash did not panic for algorithm %s", tc.algorithm)
+					}
+				}()
+			}
+
+			got := hash(tc.input, tc.algorithm)
+			if got == "" {
+				t.Errorf("hash(%q, %q) = empty string", tc.input, tc.algorithm)
+			}
+		})
+	}
+}
+
+func Test_bcryptHash(t *testing.T) {
+	hashed := bcryptHash(bcrypt.MinCost, "s3cret")
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte("s3cret")); err != nil {
+		t.Errorf("bcryptHash did not produce a matching hash: %v", err)
+	}
+	if cost, err := bcrypt.Cost([]byte(hashed)); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("bcryptHash cost = %d, %v, want %d", cost, err, bcrypt.MinCost)
+	}
+	if bcryptHash(bcrypt.MinCost, "s3cret") == hashed {
+		t.Errorf("bcryptHash should use a random salt")
+	}
+
+	tests := []struct {
+		name     string
+		cost     int
+		password string
+	}{
+		{name: "cost too low", cost: bcrypt.MinCost - 1, password: "s3cret"},
+		{name: "cost too high", cost: bcrypt.MaxCost + 1, password: "s3cret"},
+		{name: "password too long", cost: bcrypt.MinCost, password: strings.Repeat("x", 73)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("bcryptHash did not panic for cost %d", tc.cost)
+				}
+			}()
+			bcryptHash(tc.cost, tc.password)
+		})
+	}
+}
+
+func Test_crc32Checksum(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		wanted string
+	}{
+		{name: "check vector", input: "123456789", wanted: "cbf43926"},
+		{name: "single character", input: "a", wanted: "e8b7be43"},
+		{name: "empty string", input: "", wanted: "00000000"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := crc32Checksum(tc.input)
+			if got != tc.wanted {
+				t.Errorf("crc32Checksum(%q) = %q, want %q", tc.input, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_sequence(t *testing.T) {
+	se := sequence(1, 10)
+	if len(se) != 10 {
+		t.Errorf("expected length of sequence to be 10, got %d", len(se))
+	}
+	if sequence(10, 1) != nil {
+		t.Errorf("expected sequence(10, 1) to be nil")
+	}
+}
+
+func Test_pickSeeded(t *testing.T) {
+	options := []string{"shard-a", "shard-b", "shard-c", "shard-d"}
+
+	// fixed values, so a change of the selection that would move hosts to other shards is noticed
+	for seed, want := range map[string]string{"web-1": "shard-d", "web-2": "shard-b", "": "shard-a"} {
+		for range 10 {
+			if got := pickSeeded(seed, options); got != want {
+				t.Fatalf("pickSeeded(%q) = %q, want %q on every call", seed, got, want)
+			}
+		}
+	}
+	if got := pickSeeded("x", []string{"only"}); got != "only" {
+		t.Errorf("pickSeeded with one option = %q, want %q", got, "only")
+	}
+
+	counts := map[string]int{}
+	for i := range 4000 {
+		counts[pickSeeded(fmt.Sprintf("host-%d", i), options)]++
+	}
+	for _, option := range options {
+		if counts[option] < 800 || counts[option] > 1200 {
+			t.Errorf("pickSeeded chose %q %d times out of 4000, want about 1000", option, counts[option])
+		}
+	}
+
+	env := Environment{"HOST": "web-1", "SHARDS": "shard-a,shard-b,shard-c,shard-d"}
+	got, err := RenderTemplate(`{{ pickSeeded (asString "HOST") (asStringSlice "SHARDS" ",") }}`, env)
+	if err != nil || got != "shard-d" {
+		t.Errorf("pickSeeded in template = %q, %v", got, err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("pickSeeded did not panic without options")
+		}
+	}()
+	pickSeeded("x", nil)
+}
+
+func Test_backoffSeq(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   float64
+		factor float64
+		count  int
+		wanted []float64
+		panics bool
+	}{
+		{name: "doubling", base: 0.5, factor: 2, count: 5, wanted: []float64{0.5, 1, 2, 4, 8}},
+		{name: "factor 1.5", base: 100, factor: 1.5, count: 4, wanted: []float64{100, 150, 225, 337.5}},
+		{name: "constant", base: 3, factor: 1, count: 3, wanted: []float64{3, 3, 3}},
+		{name: "single", base: 1, factor: 10, count: 1, wanted: []float64{1}},
+		{name: "zero count", base: 1, factor: 2, count: 0, panics: true},
+		{name: "negative count", base: 1, factor: 2, count: -1, panics: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("backoffSeq(%v, %v, %d) panic = %v, want panic %v", tc.base, tc.factor, tc.count, r, tc.panics)
+				}
+			}()
+			got := backoffSeq(tc.base, tc.factor, tc.count)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("backoffSeq(%v, %v, %d) = %v, want %v", tc.base, tc.factor, tc.count, got, tc.wanted)
+			}
+		})
+	}
+
+	got, err := RenderTemplate(`{{ range $i, $delay := backoffSeq 0.5 2 3 }}attempt{{ $i }}={{ $delay }}s {{ end }}`, Environment{})
+	if err != nil || got != "attempt0=0.5s attempt1=1s attempt2=2s " {
+		t.Errorf("backoffSeq in template = %q, %v", got, err)
+	}
+}
+
+func Test_numCPU(t *testing.T) {
+	if got := numCPU(); got < 1 {
+		t.Errorf("numCPU() = %d, want at least 1", got)
+	}
+	got, err := RenderTemplate(`{{ numCPU }}`, Environment{})
+	if err != nil || got != strconv.Itoa(runtime.NumCPU()) {
+		t.Errorf("numCPU in template = %q, %v", got, err)
+	}
+}
+
+func Test_totalMemBytes(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wanted  int64
+	}{
+		{name: "meminfo", content: "MemTotal:       16314456 kB\nMemFree:         1234567 kB\n", wanted: 16314456 * 1024},
+		{name: "not first line", content: "MemFree: 1 kB\nMemTotal: 2048 kB\n", wanted: 2 * 1024 * 1024},
+		{name: "missing line", content: "MemFree: 1 kB\n", wanted: 0},
+		{name: "unknown unit", content: "MemTotal: 2048 MB\n", wanted: 0},
+		{name: "not a number", content: "MemTotal: lots kB\n", wanted: 0},
+		{name: "empty", content: "", wanted: 0},
+	}
+
+	original := meminfoPath
+	t.Cleanup(func() { meminfoPath = original })
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			meminfoPath = filepath.Join(dir, tc.name)
+			if err := os.WriteFile(meminfoPath, []byte(tc.content), 0644); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+			if got := totalMemBytes(); got != tc.wanted {
+				t.Errorf("totalMemBytes() = %d, want %d", got, tc.wanted)
+			}
+		})
+	}
+
+	meminfoPath = filepath.Join(dir, "missing")
+	if got := totalMemBytes(); got != 0 {
+		t.Errorf("totalMemBytes() = %d without meminfo, want 0", got)
+	}
+}
+
+func Test_hostname(t *testing.T) {
+	name := hostname()
+	if name == "" {
+		t.Fatalf("hostname() returned an empty string")
+	}
+	if want, _ := os.Hostname(); name != want {
+		t.Errorf("hostname() = %q, want %q", name, want)
+	}
+
+	got, err := RenderTemplate(`{{ hostname }}`, Environment{})
+	if err != nil || got != name {
+		t.Errorf("hostname in template = %q, %v", got, err)
+	}
+}
+
+// fakeResolver answers host and address lookups from maps, a missing entry is a lookup error
+type fakeResolver struct {
+	hosts map[string][]string
+	addrs map[string][]string
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func (r fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := r.addrs[addr]; ok {
+		return names, nil
+	}
+	return nil, fmt.Errorf("no name for %s", addr)
+}
+
+func Test_lookupFQDN(t *testing.T) {
+	original := fqdnResolver
+	t.Cleanup(func() { fqdnResolver = original })
+	fqdnResolver = fakeResolver{
+		hosts: map[string][]string{
+			"web":    {"10.0.0.1", "10.0.0.2"},
+			"single": {"10.0.0.3"},
+		},
+		addrs: map[string][]string{
+			"10.0.0.2": {"web", "web.example.com."},
+			"10.0.0.3": {"single"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		wanted string
+	}{
+		{name: "already qualified", host: "db.example.com", wanted: "db.example.com"},
+		{name: "reverse lookup", host: "web", wanted: "web.example.com"},
+		{name: "no qualified name", host: "single", wanted: "single"},
+		{name: "lookup fails", host: "unknown", wanted: "unknown"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := lookupFQDN(tc.host); got != tc.wanted {
+				t.Errorf("lookupFQDN(%q) = %q, want %q", tc.host, got, tc.wanted)
+			}
+		})
+	}
+
+	if got := fqdn(); got == "" {
+		t.Errorf("fqdn() returned an empty string")
+	}
+}
+
+func Test_getenv(t *testing.T) {
+	t.Setenv("ZEP_TEST_SET", "value")
+	t.Setenv("ZEP_TEST_EMPTY", "")
+
+	tests := []struct {
+		name   string
+		key    string
+		wanted string
+		or     string
+	}{
+		{name: "set", key: "ZEP_TEST_SET", wanted: "value", or: "value"},
+		{name: "empty", key: "ZEP_TEST_EMPTY", wanted: "", or: ""},
+		{name: "unset", key: "ZEP_TEST_UNSET", wanted: "", or: "default"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getenv(tc.key); got != tc.wanted {
+				t.Errorf("getenv(%q) = %q, want %q", tc.key, got, tc.wanted)
+			}
+			if got := getenvOr(tc.key, "default"); got != tc.or {
+				t.Errorf("getenvOr(%q) = %q, want %q", tc.key, got, tc.or)
+			}
+		})
+	}
+
+	got, err := RenderTemplate(`{{ getenv "ZEP_TEST_SET" }}`, Environment{})
+	if err != nil || got != "value" {
+		t.Errorf("getenv in template = %q, %v, want %q", got, err, "value")
+	}
+}
+
+func Test_fileExists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "override.conf")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		wanted bool
+	}{
+		{name: "existing file", path: path, wanted: true},
+		{name: "existing directory", path: dir, wanted: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.conf"), wanted: false},
+		{name: "not a directory", path: filepath.Join(path, "child"), wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := fileExists(tc.path)
+			if got != tc.wanted {
+				t.Errorf("fileExists(%q) = %v, want %v", tc.path, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_glob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.pem", "a.pem", "c.key"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	got := glob(filepath.Join(dir, "*.pem"))
+	want := []string{filepath.Join(dir, "a.pem"), filepath.Join(dir, "b.pem")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("glob = %v, want %v", got, want)
+	}
+
+	if got := glob(filepath.Join(dir, "*.crt")); len(got) != 0 {
+		t.Errorf("glob without matches = %v, want empty", got)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("glob did not panic for malformed pattern")
+		}
+	}()
+	glob("[")
+}
+
+func Test_fileExistOrDefault(t *testing.T) {
+
+	t.Run("destination file exists", func(t *testing.T) {
+		destination := t.TempDir() + "/testfile.txt"
+		os.WriteFile(destination, []byte("test"), 0644)
+		fileExistOrDefault(destination, "/no/matter/what.txt")
+	})
+
+	t.Run("destination file not exist exists", func(t *testing.T) {
+		dir := t.TempDir()
+		destination := filepath.Join(dir, "testfile.txt")
+		os.WriteFile(destination, []byte("will be deleted"), 0644)
+		os.Remove(destination)
+
+		testString := time.Now().String()
+
+		defaultPath := filepath.Join(dir, "defaultfile.txt")
+		f, e := os.OpenFile(defaultPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0777)
+		if e != nil {
+			t.Fatalf("failed to create default file: %v", e)
+		}
+		f.Write([]byte(testString))
+		f.Close()
+
+		fileInfo, err := os.Stat(defaultPath)
+		t.Logf("permissions of defaultPath is: %o", fileInfo.Mode())
+		if err != nil {
+			t.Fatalf("failed to stat default file: %v", err)
+		}
+
+		fileExistOrDefault(destination, defaultPath)
+		// read file data
+		data, err := os.ReadFile(destination)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(data) != testString {
+			t.Fatalf("expected file content to be '%s', got '%s'", string(data), testString)
+		}
+
+		// check file permissions
+		info, err := os.Stat(destination)
+		if err != nil {
+			t.Fatalf("failed to stat file: %v", err)
+		}
+		if info.Mode() != fileInfo.Mode() {
+			t.Fatalf("expected file permissions to be %o, got %o", fileInfo.Mode(), info.Mode())
+		}
+	})
+
+	t.Run("destination file not exist exists", func(t *testing.T) {
+		dir := t.TempDir()
+		destination := filepath.Join(dir, "testfile.txt")
+		defaultPath := filepath.Join(dir, "defaultPath.txt")
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("fileExistOrDefault did not panic for non-existent destination file")
+			}
+		}()
+
+		fileExistOrDefault(destination, defaultPath)
+	})
+}
+
+func Test_coalesce(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []any
+		wanted any
+	}{
+		{name: "first non-empty", values: []any{"", "b", "c"}, wanted: "b"},
+		{name: "first value", values: []any{"a", "b"}, wanted: "a"},
+		{name: "literal fallback", values: []any{"", "", "fallback"}, wanted: "fallback"},
+		{name: "whitespace only", values: []any{"  ", "\t\n", "x"}, wanted: "x"},
+		{name: "zero numbers", values: []any{0, 0.0, 5}, wanted: 5},
+		{name: "false", values: []any{false, true}, wanted: true},
+		{name: "empty slices and maps", values: []any{[]string{}, map[string]string{}, nil, "x"}, wanted: "x"},
+		{name: "all empty", values: []any{"", 0, nil}, wanted: nil},
+		{name: "no arguments", values: nil, wanted: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := coalesce(tc.values...)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("coalesce(%v) = %v, want %v", tc.values, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"B": "from-b"}
+	got, err := RenderTemplate(`{{ coalesce (asStringOr "A" "") (asStringOr "B" "") "fallback" }}`, env)
+	if err != nil || got != "from-b" {
+		t.Errorf("coalesce in template = %q, %v, want %q", got, err, "from-b")
+	}
+}
+
+func Test_regexMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		value   string
+		wanted  bool
+		panics  bool
+	}{
+		{name: "match", pattern: `^[a-z]+-\d+$`, value: "web-01", wanted: true},
+		{name: "partial match", pattern: `\d+`, value: "web-01", wanted: true},
+		{name: "no match", pattern: `^\d+$`, value: "web-01", wanted: false},
+		{name: "invalid pattern", pattern: `(`, value: "web-01", panics: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("regexMatch(%q, %q) panic = %v, want panic %v", tc.pattern, tc.value, r, tc.panics)
+				}
+			}()
+			got := regexMatch(tc.pattern, tc.value)
+			if got != tc.wanted {
+				t.Errorf("regexMatch(%q, %q) = %v, want %v", tc.pattern, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_regexReplace(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		replacement string
+		value       string
+		wanted      string
+		panics      bool
+	}{
+		{name: "replace all", pattern: `[^a-z0-9]+`, replacement: "_", value: "my-app.v2", wanted: "my_app_v2"},
+		{name: "submatch", pattern: `^(\w+)@(\w+)$`, replacement: "$2/$1", value: "user@host", wanted: "host/user"},
+		{name: "named submatch", pattern: `(?P<port>\d+)`, replacement: "[${port}]", value: "port 80", wanted: "port [80]"},
+		{name: "no match", pattern: `x`, replacement: "y", value: "abc", wanted: "abc"},
+		{name: "invalid pattern", pattern: `[`, replacement: "", value: "abc", panics: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("regexReplace(%q, %q, %q) panic = %v, want panic %v", tc.pattern, tc.replacement, tc.value, r, tc.panics)
+				}
+			}()
+			got := regexReplace(tc.pattern, tc.replacement, tc.value)
+			if got != tc.wanted {
+				t.Errorf("regexReplace(%q, %q, %q) = %q, want %q", tc.pattern, tc.replacement, tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_regexCacheConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pattern := fmt.Sprintf(`^item-%d$`, i%4)
+			if !regexMatch(pattern, fmt.Sprintf("item-%d", i%4)) {
+				t.Errorf("regexMatch(%q) = false, want true", pattern)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if compileRegex(`^item-0$`) != compileRegex(`^item-0$`) {
+		t.Errorf("compileRegex did not reuse the cached regex")
+	}
+}
+
+func BenchmarkRegexMatch(b *testing.B) {
+	hosts := make([]string, 500)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("web-%03d.example.com", i)
+	}
+	const pattern = `^web-\d+\.example\.com$`
+
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			for _, host := range hosts {
+				regexMatch(pattern, host)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			for _, host := range hosts {
+				regexp.MustCompile(pattern).MatchString(host)
+			}
+		}
+	})
+}
+
+func Test_empty(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
 		wanted bool
 	}{
-		{name: "substring present", value: "hello world", substr: "world", wanted: true},
-		{name: "substring not present", value: "hello world", substr: "foo", wanted: false},
+		{name: "nil", value: nil, wanted: true},
+		{name: "empty string", value: "", wanted: true},
+		{name: "whitespace only string", value: " \t", wanted: true},
+		{name: "zero", value: 0, wanted: true},
+		{name: "false", value: false, wanted: true},
+		{name: "empty slice", value: []string{}, wanted: true},
+		{name: "empty map", value: map[string]any{}, wanted: true},
+		{name: "string", value: "x", wanted: false},
+		{name: "number", value: 1.5, wanted: false},
+		{name: "true", value: true, wanted: false},
+		{name: "slice", value: []int{0}, wanted: false},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := contains(tc.value, tc.substr)
+			got := empty(tc.value)
 			if got != tc.wanted {
-				t.Errorf("contains(%q, %q) = %v, want %v", tc.value, tc.substr, got, tc.wanted)
+				t.Errorf("empty(%v) = %v, want %v", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_containsCaseInsensitive(t *testing.T) {
+func Test_ternary(t *testing.T) {
+	if got := ternary("on", "off", true); got != "on" {
+		t.Errorf("ternary(true) = %v, want %v", got, "on")
+	}
+	if got := ternary("on", "off", false); got != "off" {
+		t.Errorf("ternary(false) = %v, want %v", got, "off")
+	}
+
+	env := Environment{"DEBUG": "yes"}
+	got, err := RenderTemplate(`{{ asBool "DEBUG" | ternary "debug" "info" }}`, env)
+	if err != nil || got != "debug" {
+		t.Errorf("ternary in pipeline = %q, %v, want %q", got, err, "debug")
+	}
+}
+
+func Test_compact(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  []string
+		wanted []string
+	}{
+		{name: "drop empty", value: []string{"a", "", "b"}, wanted: []string{"a", "b"}},
+		{name: "drop whitespace only", value: []string{" ", "a", "\t"}, wanted: []string{"a"}},
+		{name: "all empty", value: []string{"", " "}, wanted: []string{}},
+		{name: "empty slice", value: []string{}, wanted: []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := compact(tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("compact(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_lines(t *testing.T) {
 	tests := []struct {
 		name   string
 		value  string
-		substr string
-		wanted bool
+		wanted []string
 	}{
-		{name: "substring present", value: "hello world", substr: "WORLD", wanted: true},
-		{name: "substring not present", value: "hello world", substr: "foo", wanted: false},
+		{name: "lines", value: "a\nb\nc", wanted: []string{"a", "b", "c"}},
+		{name: "trailing newline", value: "a\nb\n", wanted: []string{"a", "b"}},
+		{name: "crlf", value: "a\r\nb\r\n", wanted: []string{"a", "b"}},
+		{name: "blank lines kept", value: "a\n\nb\n\n", wanted: []string{"a", "", "b", ""}},
+		{name: "single line", value: "a", wanted: []string{"a"}},
+		{name: "only newline", value: "\n", wanted: []string{""}},
+		{name: "empty", value: "", wanted: []string{}},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := containsCaseInsensitive(tc.value, tc.substr)
+			got := lines(tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("lines(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+		})
+	}
+
+	if got := unlines([]string{"a", "b"}); got != "a\nb" {
+		t.Errorf("unlines = %q, want %q", got, "a\nb")
+	}
+	if got := unlines(nil); got != "" {
+		t.Errorf("unlines(nil) = %q, want empty", got)
+	}
+
+	env := Environment{"ALLOWLIST": "10.0.0.1\n\n10.0.0.2\n"}
+	got, err := RenderTemplate(`{{ range lines (asString "ALLOWLIST") | compact }}allow {{ . }};{{ end }}`, env)
+	if err != nil || got != "allow 10.0.0.1;allow 10.0.0.2;" {
+		t.Errorf("lines in template = %q, %v", got, err)
+	}
+}
+
+func Test_toString(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		wanted string
+	}{
+		{name: "string", value: "x", wanted: "x"},
+		{name: "bytes", value: []byte("x"), wanted: "x"},
+		{name: "int", value: 42, wanted: "42"},
+		{name: "float", value: 1.5, wanted: "1.5"},
+		{name: "bool", value: true, wanted: "true"},
+		{name: "slice", value: []string{"a", "b"}, wanted: "[a b]"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := toString(tc.value)
 			if got != tc.wanted {
-				t.Errorf("containsCaseInsensitive(%q, %q) = %v, want %v", tc.value, tc.substr, got, tc.wanted)
+				t.Errorf("toString(%v) = %q, want %q", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_containsAny(t *testing.T) {
+func Test_inSlice(t *testing.T) {
+	roles := []string{"admin", "owner"}
 	tests := []struct {
 		name   string
-		value  string
-		chars  string
+		item   string
+		slice  []string
 		wanted bool
+		index  int
 	}{
-		{name: "substring present", value: "hello world", chars: "w", wanted: true},
-		{name: "substring not present", value: "hello world", chars: "z.u", wanted: false},
+		{name: "first", item: "admin", slice: roles, wanted: true, index: 0},
+		{name: "last", item: "owner", slice: roles, wanted: true, index: 1},
+		{name: "absent", item: "guest", slice: roles, wanted: false, index: -1},
+		{name: "case sensitive", item: "Admin", slice: roles, wanted: false, index: -1},
+		{name: "duplicates", item: "a", slice: []string{"b", "a", "a"}, wanted: true, index: 1},
+		{name: "empty slice", item: "a", slice: []string{}, wanted: false, index: -1},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := containsAny(tc.value, tc.chars)
+			if got := inSlice(tc.item, tc.slice); got != tc.wanted {
+				t.Errorf("inSlice(%q, %q) = %v, want %v", tc.item, tc.slice, got, tc.wanted)
+			}
+			if got := indexOf(tc.item, tc.slice); got != tc.index {
+				t.Errorf("indexOf(%q, %q) = %v, want %v", tc.item, tc.slice, got, tc.index)
+			}
+		})
+	}
+
+	env := Environment{"ROLE": "owner", "ADMIN_ROLES": "admin,owner"}
+	got, err := RenderTemplate(`{{ if inSlice (asString "ROLE") (asStringSlice "ADMIN_ROLES" ",") }}admin{{ end }}`, env)
+	if err != nil || got != "admin" {
+		t.Errorf("inSlice in template = %q, %v, want %q", got, err, "admin")
+	}
+}
+
+func Test_toInt(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		wanted int
+		panics bool
+	}{
+		{name: "string", value: "42", wanted: 42},
+		{name: "string with spaces", value: " -7\n", wanted: -7},
+		{name: "int", value: 42, wanted: 42},
+		{name: "int64", value: int64(9), wanted: 9},
+		{name: "uint8", value: uint8(200), wanted: 200},
+		{name: "integral float", value: 3.0, wanted: 3},
+		{name: "fractional float", value: 3.5, panics: true},
+		{name: "invalid string", value: "4x", panics: true},
+		{name: "float string", value: "1.5", panics: true},
+		{name: "bool", value: true, panics: true},
+		{name: "nil", value: nil, panics: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("toInt(%v) panic = %v, want panic %v", tc.value, r, tc.panics)
+				}
+			}()
+			got := toInt(tc.value)
 			if got != tc.wanted {
-				t.Errorf("containsAny(%q, %q) = %v, want %v", tc.value, tc.chars, got, tc.wanted)
+				t.Errorf("toInt(%v) = %v, want %v", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_hasPrefix(t *testing.T) {
+func Test_toFloat(t *testing.T) {
 	tests := []struct {
 		name   string
-		value  string
-		prefix string
-		wanted bool
+		value  any
+		wanted float64
+		panics bool
 	}{
-		{name: "prefix present", value: "hello world", prefix: "hello", wanted: true},
-		{name: "prefix not present", value: "hello world", prefix: "world", wanted: false},
+		{name: "string", value: "1.5", wanted: 1.5},
+		{name: "integer string", value: " 2 ", wanted: 2},
+		{name: "exponent string", value: "1e3", wanted: 1000},
+		{name: "float", value: 0.25, wanted: 0.25},
+		{name: "float32", value: float32(0.5), wanted: 0.5},
+		{name: "int", value: -3, wanted: -3},
+		{name: "invalid string", value: "abc", panics: true},
+		{name: "bool", value: false, panics: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := hasPrefix(tc.value, tc.prefix)
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("toFloat(%v) panic = %v, want panic %v", tc.value, r, tc.panics)
+				}
+			}()
+			got := toFloat(tc.value)
 			if got != tc.wanted {
-				t.Errorf("hasPrefix(%q, %q) = %v, want %v", tc.value, tc.prefix, got, tc.wanted)
+				t.Errorf("toFloat(%v) = %v, want %v", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_hasSuffix(t *testing.T) {
+func Test_toBool(t *testing.T) {
 	tests := []struct {
 		name   string
-		value  string
-		suffix string
+		value  any
 		wanted bool
+		panics bool
 	}{
-		{name: "suffix present", value: "hello world", suffix: "world", wanted: true},
-		{name: "suffix not present", value: "hello world", suffix: "hello", wanted: false},
+		{name: "true string", value: "Yes", wanted: true},
+		{name: "false string", value: " off ", wanted: false},
+		{name: "numeric string", value: "1", wanted: true},
+		{name: "bool", value: true, wanted: true},
+		{name: "zero int", value: 0, wanted: false},
+		{name: "non-zero int", value: 5, wanted: true},
+		{name: "zero float", value: 0.0, wanted: false},
+		{name: "non-zero float", value: 0.1, wanted: true},
+		{name: "invalid string", value: "maybe", panics: true},
+		{name: "slice", value: []string{}, panics: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := hasSuffix(tc.value, tc.suffix)
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("toBool(%v) panic = %v, want panic %v", tc.value, r, tc.panics)
+				}
+			}()
+			got := toBool(tc.value)
 			if got != tc.wanted {
-				t.Errorf("hasSuffix(%q, %q) = %v, want %v", tc.value, tc.suffix, got, tc.wanted)
+				t.Errorf("toBool(%v) = %v, want %v", tc.value, got, tc.wanted)
 			}
 		})
 	}
+
+	env := Environment{"VERSION": "v12"}
+	got, err := RenderTemplate(`{{ if gt (trimPrefix "v" (asString "VERSION") | toInt) 10 }}new{{ end }}`, env)
+	if err != nil || got != "new" {
+		t.Errorf("toInt in template = %q, %v, want %q", got, err, "new")
+	}
 }
 
-func Test_toLower(t *testing.T) {
+func Test_rounding(t *testing.T) {
+	tests := []struct {
+		value float64
+		abs   float64
+		round int
+		ceil  int
+		floor int
+	}{
+		{value: 2.5, abs: 2.5, round: 3, ceil: 3, floor: 2},
+		{value: 3.5, abs: 3.5, round: 4, ceil: 4, floor: 3},
+		{value: -2.5, abs: 2.5, round: -3, ceil: -2, floor: -3},
+		{value: 2.4999, abs: 2.4999, round: 2, ceil: 3, floor: 2},
+		{value: 0.5, abs: 0.5, round: 1, ceil: 1, floor: 0},
+		{value: -0.5, abs: 0.5, round: -1, ceil: 0, floor: -1},
+		{value: 7, abs: 7, round: 7, ceil: 7, floor: 7},
+		{value: 0, abs: 0, round: 0, ceil: 0, floor: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprint(tc.value), func(t *testing.T) {
+			if got := abs(tc.value); got != tc.abs {
+				t.Errorf("abs(%v) = %v, want %v", tc.value, got, tc.abs)
+			}
+			if got := round(tc.value); got != tc.round {
+				t.Errorf("round(%v) = %v, want %v", tc.value, got, tc.round)
+			}
+			if got := ceil(tc.value); got != tc.ceil {
+				t.Errorf("ceil(%v) = %v, want %v", tc.value, got, tc.ceil)
+			}
+			if got := floor(tc.value); got != tc.floor {
+				t.Errorf("floor(%v) = %v, want %v", tc.value, got, tc.floor)
+			}
+		})
+	}
+
+	env := Environment{"RATIO": "-0.75"}
+	got, err := RenderTemplate(`{{ asFloat "RATIO" | abs }} {{ round 2.5 }} {{ ceil 1.2 }} {{ floor 1.8 }}`, env)
+	if err != nil || got != "0.75 3 2 1" {
+		t.Errorf("rounding in template = %q, %v, want %q", got, err, "0.75 3 2 1")
+	}
+}
+
+func Test_zip(t *testing.T) {
 	tests := []struct {
 		name   string
-		value  string
-		wanted string
+		keys   []string
+		values []string
+		wanted map[string]string
+		panics bool
 	}{
-		{name: "lowercase", value: "hello", wanted: "hello"},
-		{name: "uppercase", value: "HELLO", wanted: "hello"},
-		{name: "mixed case", value: "HeLLo", wanted: "hello"},
+		{name: "pairs", keys: []string{"us", "de", "fr"}, values: []string{"USA", "Germany", "France"}, wanted: map[string]string{"us": "USA", "de": "Germany", "fr": "France"}},
+		{name: "repeated key", keys: []string{"a", "a"}, values: []string{"1", "2"}, wanted: map[string]string{"a": "2"}},
+		{name: "empty", keys: []string{}, values: []string{}, wanted: map[string]string{}},
+		{name: "more keys", keys: []string{"a", "b"}, values: []string{"1"}, panics: true},
+		{name: "more values", keys: []string{"a"}, values: []string{"1", "2"}, panics: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := toLower(tc.value)
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("zip(%q, %q) panic = %v, want panic %v", tc.keys, tc.values, r, tc.panics)
+				}
+			}()
+			got := zip(tc.keys, tc.values)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("zip(%q, %q) = %v, want %v", tc.keys, tc.values, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"CODES": "us,de", "NAMES": "USA,Germany", "COUNTRY": "de"}
+	got, err := RenderTemplate(`{{ index (zip (asStringSlice "CODES" ",") (asStringSlice "NAMES" ",")) (asString "COUNTRY") }}`, env)
+	if err != nil || got != "Germany" {
+		t.Errorf("zip in template = %q, %v, want %q", got, err, "Germany")
+	}
+}
+
+func Test_chunk(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		value  []string
+		wanted [][]string
+		panics bool
+	}{
+		{name: "multiple of size", size: 2, value: []string{"a", "b", "c", "d"}, wanted: [][]string{{"a", "b"}, {"c", "d"}}},
+		{name: "shorter last chunk", size: 2, value: []string{"a", "b", "c"}, wanted: [][]string{{"a", "b"}, {"c"}}},
+		{name: "size larger than slice", size: 5, value: []string{"a", "b"}, wanted: [][]string{{"a", "b"}}},
+		{name: "size one", size: 1, value: []string{"a", "b"}, wanted: [][]string{{"a"}, {"b"}}},
+		{name: "empty", size: 3, value: []string{}, wanted: [][]string{}},
+		{name: "zero size", size: 0, value: []string{"a"}, panics: true},
+		{name: "negative size", size: -1, value: []string{"a"}, panics: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("chunk(%d, %q) panic = %v, want panic %v", tc.size, tc.value, r, tc.panics)
+				}
+			}()
+			got := chunk(tc.size, tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("chunk(%d, %q) = %q, want %q", tc.size, tc.value, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"SERVERS": "a,b,c"}
+	got, err := RenderTemplate(`{{ range $i, $group := asStringSlice "SERVERS" "," | chunk 2 }}group{{ $i }}:{{ unlines $group }};{{ end }}`, env)
+	if err != nil || got != "group0:a\nb;group1:c;" {
+		t.Errorf("chunk in template = %q, %v", got, err)
+	}
+}
+
+func Test_reverseList(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  []string
+		wanted []string
+	}{
+		{name: "several", value: []string{"a", "b", "c"}, wanted: []string{"c", "b", "a"}},
+		{name: "single", value: []string{"a"}, wanted: []string{"a"}},
+		{name: "multibyte elements", value: []string{"日本", "语"}, wanted: []string{"语", "日本"}},
+		{name: "empty", value: []string{}, wanted: []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := slices.Clone(tc.value)
+			got := reverseList(tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("reverseList(%q) = %q, want %q", tc.value, got, tc.wanted)
+			}
+			if !reflect.DeepEqual(tc.value, input) {
+				t.Errorf("reverseList modified its input to %q", tc.value)
+			}
+		})
+	}
+
+	env := Environment{"SERVERS": "a,b,c"}
+	got, err := RenderTemplate(`{{ asStringSlice "SERVERS" "," | reverseList | unlines }}`, env)
+	if err != nil || got != "c\nb\na" {
+		t.Errorf("reverseList in template = %q, %v", got, err)
+	}
+}
+
+func Test_count(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     any
+		wanted    int
+		wantPanic bool
+	}{
+		{name: "string", value: "hello", wanted: 5, wantPanic: false},
+		{name: "multibyte string", value: "日本語", wanted: 3, wantPanic: false},
+		{name: "string slice", value: []string{"a", "b", "c"}, wanted: 3, wantPanic: false},
+		{name: "int slice", value: []int{1, 2}, wanted: 2, wantPanic: false},
+		{name: "array", value: [2]int{1, 2}, wanted: 2, wantPanic: false},
+		{name: "map", value: map[string]string{"a": "1"}, wanted: 1, wantPanic: false},
+		{name: "empty slice", value: []string{}, wanted: 0, wantPanic: false},
+		{name: "integer", value: 42, wanted: 0, wantPanic: true},
+		{name: "nil", value: nil, wanted: 0, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("count did not panic for value %v", tc.value)
+					}
+				}()
+			}
+
+			got := count(tc.value)
 			if got != tc.wanted {
-				t.Errorf("toLower(%q) = %q, want %q", tc.value, got, tc.wanted)
+				t.Errorf("count(%v) = %d, want %d", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_topoSort(t *testing.T) {
+	tests := []struct {
+		name   string
+		edges  map[string][]string
+		wanted []string
+	}{
+		{
+			name:   "linear chain",
+			edges:  map[string][]string{"app": {"db"}, "db": {"network"}},
+			wanted: []string{"network", "db", "app"},
+		},
+		{
+			name:   "diamond",
+			edges:  map[string][]string{"app": {"cache", "db"}, "cache": {"network"}, "db": {"network"}},
+			wanted: []string{"network", "cache", "db", "app"},
+		},
+		{
+			name:   "independent nodes",
+			edges:  map[string][]string{"c": nil, "a": nil, "b": {}},
+			wanted: []string{"a", "b", "c"},
+		},
+		{
+			name:   "empty graph",
+			edges:  map[string][]string{},
+			wanted: []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := topoSort(tc.edges)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("topoSort(%v) = %v, want %v", tc.edges, got, tc.wanted)
+			}
+		})
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatalf("topoSort did not panic for a cycle")
+			}
+			want := "dependency cycle detected: a -> b -> c -> a"
+			if err, ok := r.(error); !ok || err.Error() != want {
+				t.Errorf("topoSort panic = %v, want %q", r, want)
+			}
+		}()
+		topoSort(map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}})
+	})
+}
+
+func Test_uniqCaseInsensitive(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  []string
+		wanted []string
+	}{
+		{name: "collapse duplicates", value: []string{"A", "a", "B"}, wanted: []string{"A", "B"}},
+		{name: "first-seen casing", value: []string{"Example.COM", "example.com", "EXAMPLE.com", "host"}, wanted: []string{"Example.COM", "host"}},
+		{name: "empty slice", value: []string{}, wanted: []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := uniqCaseInsensitive(tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("uniqCaseInsensitive(%v) = %v, want %v", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_dict(t *testing.T) {
+	got := dict("name", "app", "port", 8080)
+	want := map[string]any{"name": "app", "port": 8080}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dict = %v, want %v", got, want)
+	}
+
+	t.Run("odd arguments", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("dict did not panic for odd number of arguments")
+			}
+		}()
+		dict("name")
+	})
+
+	t.Run("non-string key", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("dict did not panic for non-string key")
+			}
+		}()
+		dict(1, "value")
+	})
+}
+
+func Test_merge(t *testing.T) {
+	defaults := map[string]any{"host": "localhost", "port": 80, "tls": map[string]any{"enabled": false, "cert": "/etc/cert"}}
+	overrides := map[string]any{"port": 443, "tls": map[string]any{"enabled": true}}
+
+	tests := []struct {
+		name   string
+		merge  func(dst, src map[string]any) map[string]any
+		wanted map[string]any
+	}{
+		{
+			name:   "shallow",
+			merge:  merge,
+			wanted: map[string]any{"host": "localhost", "port": 443, "tls": map[string]any{"enabled": true}},
+		},
+		{
+			name:   "deep",
+			merge:  mergeDeep,
+			wanted: map[string]any{"host": "localhost", "port": 443, "tls": map[string]any{"enabled": true, "cert": "/etc/cert"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.merge(defaults, overrides)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("merge = %v, want %v", got, tc.wanted)
+			}
+			if !reflect.DeepEqual(defaults, map[string]any{"host": "localhost", "port": 80, "tls": map[string]any{"enabled": false, "cert": "/etc/cert"}}) {
+				t.Errorf("merge modified dst: %v", defaults)
+			}
+			if !reflect.DeepEqual(overrides, map[string]any{"port": 443, "tls": map[string]any{"enabled": true}}) {
+				t.Errorf("merge modified src: %v", overrides)
+			}
+		})
+	}
+
+	env := Environment{"CONFIG": `{"db": {"port": 5433}}`}
+	got, err := RenderTemplate(`{{ $c := mergeDeep (dict "db" (dict "host" "localhost" "port" 5432)) (fromJson (asString "CONFIG")) }}{{ toJson $c }}`, env)
+	if err != nil || got != `{"db":{"host":"localhost","port":5433}}` {
+		t.Errorf("mergeDeep in template = %q, %v", got, err)
+	}
+}
+
+func Test_dig(t *testing.T) {
+	config := fromJson(`{"database": {"host": "db", "replicas": ["r1", "r2"], "pool": {"size": 10}}, "empty": null}`)
+
+	tests := []struct {
+		name   string
+		path   string
+		value  any
+		wanted any
+	}{
+		{name: "nested key", path: "database.host", value: config, wanted: "db"},
+		{name: "slice", path: "database.replicas", value: config, wanted: []any{"r1", "r2"}},
+		{name: "slice index", path: "database.replicas.1", value: config, wanted: "r2"},
+		{name: "deep number", path: "database.pool.size", value: config, wanted: float64(10)},
+		{name: "missing key", path: "database.port", value: config, wanted: nil},
+		{name: "missing parent", path: "cache.host", value: config, wanted: nil},
+		{name: "index out of range", path: "database.replicas.2", value: config, wanted: nil},
+		{name: "negative index", path: "database.replicas.-1", value: config, wanted: nil},
+		{name: "non numeric index", path: "database.replicas.first", value: config, wanted: nil},
+		{name: "through scalar", path: "database.host.name", value: config, wanted: nil},
+		{name: "null value", path: "empty", value: config, wanted: nil},
+		{name: "empty path", path: "", value: "x", wanted: "x"},
+		{name: "string map", path: "KEY", value: map[string]string{"KEY": "v"}, wanted: "v"},
+		{name: "nil value", path: "a", value: nil, wanted: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := dig(tc.path, tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("dig(%q) = %v, want %v", tc.path, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"CONFIG": `{"database": {"replicas": ["r1", "r2"]}}`}
+	got, err := RenderTemplate(`{{ range dig "database.replicas" (fromJson (asString "CONFIG")) }}{{ . }};{{ end }}`, env)
+	if err != nil || got != "r1;r2;" {
+		t.Errorf("dig in template = %q, %v", got, err)
+	}
+}
+
+func Test_hasKey(t *testing.T) {
+	labels := map[string]string{"team": "core", "empty": ""}
+	values := map[string]any{"team": "core", "nil": nil}
+
+	tests := []struct {
+		name   string
+		key    string
+		wanted bool
+	}{
+		{name: "present key", key: "team", wanted: true},
+		{name: "missing key", key: "owner", wanted: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasKey(labels, tc.key); got != tc.wanted {
+				t.Errorf("hasKey(%v, %q) = %v, want %v", labels, tc.key, got, tc.wanted)
+			}
+			if got := hasKeyAny(values, tc.key); got != tc.wanted {
+				t.Errorf("hasKeyAny(%v, %q) = %v, want %v", values, tc.key, got, tc.wanted)
 			}
 		})
 	}
+
+	if !hasKey(labels, "empty") {
+		t.Errorf("hasKey should return true for a key with an empty value")
+	}
+	if !hasKeyAny(values, "nil") {
+		t.Errorf("hasKeyAny should return true for a key with a nil value")
+	}
 }
 
-func Test_toUpper(t *testing.T) {
+func Test_toToml(t *testing.T) {
 	tests := []struct {
-		name   string
-		value  string
-		wanted string
+		name      string
+		value     any
+		wanted    string
+		wantPanic bool
 	}{
-		{name: "lowercase", value: "hello", wanted: "HELLO"},
-		{name: "uppercase", value: "HELLO", wanted: "HELLO"},
-		{name: "mixed case", value: "HeLLo", wanted: "HELLO"},
+		{
+			name:   "sorted keys",
+			value:  map[string]any{"b": 1, "a": "x", "c": true},
+			wanted: "a = \"x\"\nb = 1\nc = true\n",
+		},
+		{
+			name: "nested tables",
+			value: map[string]any{
+				"title": "app",
+				"server": map[string]any{
+					"port": 8080,
+					"host": "localhost",
+					"tls":  map[string]any{"enabled": false},
+				},
+				"database": map[string]any{"hosts": []string{"db1", "db2"}},
+			},
+			wanted: "title = \"app\"\n\n[database]\n  hosts = [\"db1\", \"db2\"]\n\n[server]\n  host = \"localhost\"\n  port = 8080\n  [server.tls]\n    enabled = false\n",
+		},
+		{name: "string map", value: map[string]string{"key": `say "hi"`}, wanted: "key = \"say \\\"hi\\\"\"\n"},
+		{name: "empty map", value: map[string]any{}, wanted: ""},
+		{name: "slice", value: []string{"a"}, wantPanic: true},
+		{name: "scalar", value: "a", wantPanic: true},
+		{name: "unsupported value", value: map[string]any{"f": func() {}}, wantPanic: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := toUpper(tc.value)
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("toToml did not panic for value %T", tc.value)
+					}
+				}()
+			}
+
+			got := toToml(tc.value)
 			if got != tc.wanted {
-				t.Errorf("toUpper(%q) = %q, want %q", tc.value, got, tc.wanted)
+				t.Errorf("toToml(%v) = %q, want %q", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_trim(t *testing.T) {
+func Test_toJson(t *testing.T) {
 	tests := []struct {
-		name   string
-		value  string
-		cutset string
-		wanted string
+		name      string
+		value     any
+		wanted    string
+		wantPanic bool
 	}{
-		{name: "trim spaces", value: "  hello  ", cutset: " ", wanted: "hello"},
-		{name: "trim asterisks", value: "***hello***", cutset: "*", wanted: "hello"},
-		{name: "trim mixed", value: "  ***hello***  ", cutset: " *", wanted: "hello"},
+		{name: "map", value: map[string]any{"b": 1, "a": "x"}, wanted: `{"a":"x","b":1}`, wantPanic: false},
+		{name: "slice", value: []string{"a", "b"}, wanted: `["a","b"]`, wantPanic: false},
+		{name: "string", value: `say "hi"`, wanted: `"say \"hi\""`, wantPanic: false},
+		{name: "unsupported", value: func() {}, wanted: "", wantPanic: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := trim(tc.value, tc.cutset)
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("toJson did not panic for value %T", tc.value)
+					}
+				}()
+			}
+
+			got := toJson(tc.value)
 			if got != tc.wanted {
-				t.Errorf("trim(%q, %q) = %q, want %q", tc.value, tc.cutset, got, tc.wanted)
+				t.Errorf("toJson(%v) = %q, want %q", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_trimLeft(t *testing.T) {
+func Test_fromJson(t *testing.T) {
 	tests := []struct {
-		name   string
-		value  string
-		cutset string
-		wanted string
+		name      string
+		value     string
+		wanted    any
+		wantPanic bool
 	}{
-		{name: "trim spaces", value: "  hello  ", cutset: " ", wanted: "hello  "},
-		{name: "trim asterisks", value: "***hello***", cutset: "*", wanted: "hello***"},
-		{name: "trim mixed", value: "  ***hello***  ", cutset: " *", wanted: "hello***  "},
+		{name: "object", value: `{"a":"x","b":[1,2]}`, wanted: map[string]any{"a": "x", "b": []any{1.0, 2.0}}, wantPanic: false},
+		{name: "array", value: `["a","b"]`, wanted: []any{"a", "b"}, wantPanic: false},
+		{name: "null", value: `null`, wanted: nil, wantPanic: false},
+		{name: "invalid", value: `{"a":`, wanted: nil, wantPanic: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := trimLeft(tc.value, tc.cutset)
-			if got != tc.wanted {
-				t.Errorf("trimLeft(%q, %q) = %q, want %q", tc.value, tc.cutset, got, tc.wanted)
+			if tc.wantPanic {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("fromJson did not panic for value %q", tc.value)
+					}
+				}()
+			}
+
+			got := fromJson(tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("fromJson(%q) = %v, want %v", tc.value, got, tc.wanted)
 			}
 		})
 	}
+
+	env := Environment{"HOSTS": "a,b"}
+	got, err := RenderTemplate(`{{ range fromJson (toJson (asStringSlice "HOSTS" ",")) }}[{{ . }}]{{ end }}`, env)
+	if err != nil || got != "[a][b]" {
+		t.Errorf("toJson/fromJson roundtrip = %q, %v, want %q", got, err, "[a][b]")
+	}
 }
 
-func Test_trimRight(t *testing.T) {
-	tests := []struct {
-		name   string
-		value  string
-		cutset string
-		wanted string
-	}{
-		{name: "trim spaces", value: "  hello  ", cutset: " ", wanted: "  hello"},
-		{name: "trim asterisks", value: "***hello***", cutset: "*", wanted: "***hello"},
-		{name: "trim mixed", value: "  ***hello***  ", cutset: " *", wanted: "  ***hello"},
+func Test_bundle(t *testing.T) {
+	env := Environment{"HOSTS": "a,b,c", "PORT": "8080"}
+	templateContent := `{{ bundle (dict "hosts" (asStringSlice "HOSTS" ",") "port" (asInt "PORT") "tls" (dict "enabled" true)) }}`
+	output, err := RenderTemplate(templateContent, env)
+	if err != nil {
+		t.Fatalf("RenderTemplate returned error: %v", err)
 	}
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			got := trimRight(tc.value, tc.cutset)
-			if got != tc.wanted {
-				t.Errorf("trimRight(%q, %q) = %q, want %q", tc.value, tc.cutset, got, tc.wanted)
+	want := `{"hosts":["a","b","c"],"port":8080,"tls":{"enabled":true}}`
+	sections := strings.Split(output, "--- ")[1:]
+	if len(sections) != 3 {
+		t.Fatalf("expected 3 sections, got %d in %q", len(sections), output)
+	}
+
+	for _, section := range sections {
+		format, data, _ := strings.Cut(section, " ---\n")
+		t.Run(format, func(t *testing.T) {
+			var parsed map[string]any
+			var err error
+			switch format {
+			case "json":
+				err = json.Unmarshal([]byte(data), &parsed)
+			case "yaml":
+				err = yaml.Unmarshal([]byte(data), &parsed)
+			case "toml":
+				err = toml.Unmarshal([]byte(data), &parsed)
+			default:
+				t.Fatalf("unexpected format %q", format)
+			}
+			if err != nil {
+				t.Fatalf("could not parse %s section: %v", format, err)
+			}
+			got, _ := json.Marshal(parsed)
+			if string(got) != want {
+				t.Errorf("%s section parsed to %s, want %s", format, got, want)
 			}
 		})
 	}
+
+	t.Run("non-map value", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("bundle did not panic for non-map value")
+			}
+		}()
+		bundle([]string{"a"})
+	})
 }
 
-func Test_trimSpace(t *testing.T) {
+func TestRenderTemplateHTML(t *testing.T) {
+	env := Environment{"TITLE": "<script>alert('x')</script>", "URL": "javascript:alert(1)"}
+
 	tests := []struct {
-		name   string
-		value  string
-		wanted string
+		name            string
+		templateContent string
+		html            bool
+		want            string
 	}{
-		{name: "trim spaces", value: "  hello  ", wanted: "hello"},
-		{name: "trim asterisks", value: "***hello***", wanted: "***hello***"},
-		{name: "trim mixed", value: "  ***hello***  ", wanted: "***hello***"},
+		{
+			name:            "text template",
+			templateContent: `<h1>{{ asString "TITLE" }}</h1>`,
+			html:            false,
+			want:            "<h1><script>alert('x')</script></h1>",
+		},
+		{
+			name:            "html template",
+			templateContent: `<h1>{{ asString "TITLE" }}</h1>`,
+			html:            true,
+			want:            "<h1>&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;</h1>",
+		},
+		{
+			name:            "html attribute context",
+			templateContent: `<a href="{{ asString "URL" }}">link</a>`,
+			html:            true,
+			want:            `<a href="#ZgotmplZ">link</a>`,
+		},
+		{
+			name:            "html include",
+			templateContent: `{{ define "title" }}<b>{{ asString "TITLE" }}</b>{{ end }}<h1>{{ include "title" . }}</h1>`,
+			html:            true,
+			want:            "<h1><b>&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;</b></h1>",
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := trimSpace(tc.value)
-			if got != tc.wanted {
-				t.Errorf("trimSpace(%q) = %q, want %q", tc.value, got, tc.wanted)
+			got, err := RenderTemplateWithOptions("page", tc.templateContent, env, Options{HTML: tc.html})
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.want)
 			}
 		})
 	}
+
+	_, err := RenderTemplateWithOptions("page", "{{ .NAME", env, Options{HTML: true})
+	if err == nil {
+		t.Errorf("expected parse error for invalid html template")
+	}
 }
 
-func Test_base64Encode(t *testing.T) {
+func TestRenderTemplateFailOnEmpty(t *testing.T) {
+	env := Environment{"NAME": "zep", "BLANK": "  \t", "EMPTY": ""}
+
 	tests := []struct {
-		name   string
-		value  string
-		wanted string
+		name            string
+		templateContent string
+		failOnEmpty     bool
+		want            string
+		wantErr         string
 	}{
-		{name: "simple string", value: "hello", wanted: "aGVsbG8="},
-		{name: "empty string", value: "", wanted: ""},
+		{name: "accessor whitespace only", templateContent: `{{ asString "BLANK" }}`, failOnEmpty: true, wantErr: "environment variable 'BLANK' is empty or whitespace only"},
+		{name: "accessor empty", templateContent: `{{ asString "EMPTY" }}`, failOnEmpty: true, wantErr: "environment variable 'EMPTY' is empty or whitespace only"},
+		{name: "accessor missing", templateContent: `{{ asString "MISSING" }}`, failOnEmpty: true, wantErr: "environment variable 'MISSING' not found"},
+		{name: "field whitespace only", templateContent: `{{ .BLANK }}`, failOnEmpty: true, wantErr: "environment variable 'BLANK' is empty or whitespace only"},
+		{name: "field missing", templateContent: `{{ .MISSING }}`, failOnEmpty: true, wantErr: `map has no entry for key "MISSING"`},
+		{name: "default value", templateContent: `{{ asStringOr "BLANK" "fallback" }}`, failOnEmpty: true, want: "fallback"},
+		{name: "non-empty value", templateContent: `{{ asString "NAME" }} {{ .NAME }}`, failOnEmpty: true, want: "zep zep"},
+		{name: "disabled", templateContent: `[{{ asString "BLANK" }}]`, failOnEmpty: false, want: "[  \t]"},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := base64Encode(tc.value)
-			if got != tc.wanted {
-				t.Errorf("base64Encode(%q) = %q, want %q", tc.value, got, tc.wanted)
+			got, err := RenderTemplateWithOptions("config", tc.templateContent, env, Options{FailOnEmpty: tc.failOnEmpty})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("RenderTemplateWithOptions error = %v, want it to contain %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.want)
 			}
 		})
 	}
+
+	if !reflect.DeepEqual(env.WithoutEmpty(), Environment{"NAME": "zep"}) {
+		t.Errorf("WithoutEmpty = %v, want only NAME", env.WithoutEmpty())
+	}
 }
 
-func Test_base64Decode(t *testing.T) {
+func TestRenderTemplateTo(t *testing.T) {
+	env := Environment{"NAME": "zep", "LIST": "a,b,c"}
+
+	var buf strings.Builder
+	if err := RenderTemplateTo(&buf, `{{ range asStringSlice "LIST" "," }}{{ $.NAME }};{{ end }}`, env); err != nil {
+		t.Fatalf("RenderTemplateTo returned error: %v", err)
+	}
+	if buf.String() != "zep;zep;zep;" {
+		t.Errorf("RenderTemplateTo = %q, want %q", buf.String(), "zep;zep;zep;")
+	}
+
+	buf.Reset()
+	if err := RenderTemplateTo(&buf, `{{ .NAME }}{{ asString "MISSING" }}`, env); err == nil {
+		t.Errorf("expected error for missing key but got output %q", buf.String())
+	}
+
+	buf.Reset()
+	err := RenderTemplateWithOptionsTo(&buf, "config", "{{ .NAME }}\n", env, Options{AnnotateSource: true, CommentPrefix: "#"})
+	if err != nil {
+		t.Fatalf("RenderTemplateWithOptionsTo returned error: %v", err)
+	}
+	if buf.String() != "# [config] zep\n" {
+		t.Errorf("RenderTemplateWithOptionsTo = %q, want %q", buf.String(), "# [config] zep\n")
+	}
+}
+
+func Test_toPowerShellEnv(t *testing.T) {
 	tests := []struct {
 		name      string
-		value     string
+		value     map[string]string
 		wanted    string
 		wantPanic bool
 	}{
-		{name: "valid base64", value: "aGVsbG8=", wanted: "hello", wantPanic: false},
-		{name: "invalid base64", value: "invalid-base64", wanted: "", wantPanic: true},
+		{name: "double quotes", value: map[string]string{"MSG": `say "hi"`}, wanted: "$env:MSG = \"say \"\"hi\"\"\"\n"},
+		{name: "typographic double quotes", value: map[string]string{"MSG": "“a” „b“"}, wanted: "$env:MSG = \"`“a`” `„b`“\"\n"},
+		{name: "backtick", value: map[string]string{"TICK": "a`b"}, wanted: "$env:TICK = \"a``b\"\n"},
+		{name: "dollar sign", value: map[string]string{"PRICE": "$5"}, wanted: "$env:PRICE = \"`$5\"\n"},
+		{name: "sorted output", value: map[string]string{"B": "2", "A": "1", "C": "3"}, wanted: "$env:A = \"1\"\n$env:B = \"2\"\n$env:C = \"3\"\n"},
+		{name: "empty map", value: map[string]string{}, wanted: ""},
+		{name: "injected key", value: map[string]string{"A; Remove-Item x; $y": "1"}, wantPanic: true},
+		{name: "key with leading digit", value: map[string]string{"1A": "1"}, wantPanic: true},
 	}
 
 	for _, tc := range tests {
@@ -921,32 +4426,36 @@ func Test_base64Decode(t *testing.T) {
 			if tc.wantPanic {
 				defer func() {
 					if r := recover(); r == nil {
-						t.Errorf("base64Decode did not panic for value %s", tc.value)
+						t.Errorf("toPowerShellEnv did not panic for %v", tc.value)
 					}
 				}()
 			}
 
-			got := base64Decode(tc.value)
+			got := toPowerShellEnv(tc.value)
 			if got != tc.wanted {
-				t.Errorf("base64Decode(%q) = %q, want %q", tc.value, got, tc.wanted)
+				t.Errorf("toPowerShellEnv(%v) = %q, want %q", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_hash(t *testing.T) {
+func Test_toBatchEnv(t *testing.T) {
 	tests := []struct {
 		name      string
-		input     string
-		algorithm string // md5, sha1, sha224, sha256, sha512 otherwise panic
+		value     map[string]string
+		wanted    string
 		wantPanic bool
 	}{
-		{name: "valid md5", input: "hello", algorithm: "md5", wantPanic: false},
-		{name: "valid sha1", input: "hello", algorithm: "sha1", wantPanic: false},
-		{name: "valid sha224", input: "hello", algorithm: "sha224", wantPanic: false},
-		{name: "valid sha256", input: "hello", algorithm: "sha256", wantPanic: false},
-		{name: "valid sha512", input: "hello", algorithm: "sha512", wantPanic: false},
-		{name: "invalid algorithm", input: "hello", algorithm: "invalid", wantPanic: true},
+		{name: "percent", value: map[string]string{"RATE": "50%"}, wanted: "set \"RATE=50%%\"\n"},
+		{name: "ampersand", value: map[string]string{"CMD": "a&b"}, wanted: "set \"CMD=a&b\"\n"},
+		{name: "caret and pipe", value: map[string]string{"X": "^|<>()"}, wanted: "set \"X=^|<>()\"\n"},
+		{name: "exclamation mark", value: map[string]string{"X": "hi!"}, wanted: "set \"X=hi!\"\n"},
+		{name: "sorted output", value: map[string]string{"B": "2", "A": "1", "C": "3"}, wanted: "set \"A=1\"\nset \"B=2\"\nset \"C=3\"\n"},
+		{name: "empty map", value: map[string]string{}, wanted: ""},
+		{name: "double quote", value: map[string]string{"X": `say "hi"`}, wantPanic: true},
+		{name: "line break", value: map[string]string{"X": "a\r\ncalc"}, wantPanic: true},
+		{name: "newline", value: map[string]string{"X": "a\ncalc"}, wantPanic: true},
+		{name: "injected key", value: map[string]string{"X=1\" & calc & \"": "1"}, wantPanic: true},
 	}
 
 	for _, tc := range tests {
@@ -954,89 +4463,208 @@ func Test_hash(t *testing.T) {
 			if tc.wantPanic {
 				defer func() {
 					if r := recover(); r == nil {
-						t.Errorf("hash did not panic for algorithm %s", tc.algorithm)
+						t.Errorf("toBatchEnv did not panic for %v", tc.value)
 					}
 				}()
 			}
 
-			got := hash(tc.input, tc.algorithm)
-			if got == "" {
-				t.Errorf("hash(%q, %q) = empty string", tc.input, tc.algorithm)
+			got := toBatchEnv(tc.value)
+			if got != tc.wanted {
+				t.Errorf("toBatchEnv(%v) = %q, want %q", tc.value, got, tc.wanted)
 			}
 		})
 	}
 }
 
-func Test_sequence(t *testing.T) {
-	se := sequence(1, 10)
-	if len(se) != 10 {
-		t.Errorf("expected length of sequence to be 10, got %d", len(se))
+func Test_yamlFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "database:\n  host: db.local\n  port: 5432\n  replicas:\n    - db1\n    - db2\nfeatures:\n  cache: true\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
 	}
-	if sequence(10, 1) != nil {
-		t.Errorf("expected sequence(10, 1) to be nil")
+	invalidPath := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalidPath, []byte("a: [b"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	emptyPath := filepath.Join(dir, "empty.yaml")
+	if err := os.WriteFile(emptyPath, nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		dotPath   string
+		wanted    any
+		wantPanic bool
+	}{
+		{name: "string", path: path, dotPath: "database.host", wanted: "db.local"},
+		{name: "int", path: path, dotPath: "database.port", wanted: 5432},
+		{name: "bool", path: path, dotPath: "features.cache", wanted: true},
+		{name: "list index", path: path, dotPath: "database.replicas.1", wanted: "db2"},
+		{name: "map", path: path, dotPath: "features", wanted: map[string]any{"cache": true}},
+		{name: "missing key", path: path, dotPath: "database.user", wanted: nil},
+		{name: "missing index", path: path, dotPath: "database.replicas.5", wanted: nil},
+		{name: "empty file", path: emptyPath, dotPath: "a", wanted: nil},
+		{name: "invalid yaml", path: invalidPath, dotPath: "a", wantPanic: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.yaml"), dotPath: "a", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.wantPanic {
+					t.Errorf("yamlFile(%q, %q) panic = %v, want panic %v", tc.path, tc.dotPath, r, tc.wantPanic)
+				}
+			}()
+			got := yamlFile(tc.path, tc.dotPath)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("yamlFile(%q, %q) = %#v, want %#v", tc.path, tc.dotPath, got, tc.wanted)
+			}
+		})
+	}
+
+	env := Environment{"CONFIG": path, "DB_HOST": "override.local"}
+	got, err := RenderTemplate(`{{ coalesce (asStringOr "DB_HOST" "") (yamlFile (asString "CONFIG") "database.host") }}:{{ yamlFile (asString "CONFIG") "database.port" }}`, env)
+	if err != nil || got != "override.local:5432" {
+		t.Errorf("yamlFile in template = %q, %v", got, err)
 	}
 }
 
-func Test_fileExistOrDefault(t *testing.T) {
+func Test_jsonFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "defaults.json")
+	content := `{"server": {"host": "localhost", "port": 8080, "tls": false}, "upstreams": ["a:80", "b:80"], "nothing": null}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	invalidPath := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalidPath, []byte(`{"a": `), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
 
-	t.Run("destination file exists", func(t *testing.T) {
-		destination := t.TempDir() + "/testfile.txt"
-		os.WriteFile(destination, []byte("test"), 0644)
-		fileExistOrDefault(destination, "/no/matter/what.txt")
-	})
+	tests := []struct {
+		name      string
+		path      string
+		dotPath   string
+		wanted    any
+		wantPanic bool
+	}{
+		{name: "string", path: path, dotPath: "server.host", wanted: "localhost"},
+		{name: "number", path: path, dotPath: "server.port", wanted: 8080.0},
+		{name: "bool", path: path, dotPath: "server.tls", wanted: false},
+		{name: "array index", path: path, dotPath: "upstreams.0", wanted: "a:80"},
+		{name: "null", path: path, dotPath: "nothing", wanted: nil},
+		{name: "missing key", path: path, dotPath: "server.user", wanted: nil},
+		{name: "path below scalar", path: path, dotPath: "server.host.name", wanted: nil},
+		{name: "invalid json", path: invalidPath, dotPath: "a", wantPanic: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.json"), dotPath: "a", wantPanic: true},
+	}
 
-	t.Run("destination file not exist exists", func(t *testing.T) {
-		dir := t.TempDir()
-		destination := filepath.Join(dir, "testfile.txt")
-		os.WriteFile(destination, []byte("will be deleted"), 0644)
-		os.Remove(destination)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.wantPanic {
+					t.Errorf("jsonFile(%q, %q) panic = %v, want panic %v", tc.path, tc.dotPath, r, tc.wantPanic)
+				}
+			}()
+			got := jsonFile(tc.path, tc.dotPath)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("jsonFile(%q, %q) = %#v, want %#v", tc.path, tc.dotPath, got, tc.wanted)
+			}
+		})
+	}
 
-		testString := time.Now().String()
+	env := Environment{"DEFAULTS": path}
+	got, err := RenderTemplate(`listen {{ asPortOr "PORT" (jsonFile (asString "DEFAULTS") "server.port" | toInt) }};`, env)
+	if err != nil || got != "listen 8080;" {
+		t.Errorf("jsonFile in template = %q, %v", got, err)
+	}
+}
 
-		defaultPath := filepath.Join(dir, "defaultfile.txt")
-		f, e := os.OpenFile(defaultPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0777)
-		if e != nil {
-			t.Fatalf("failed to create default file: %v", e)
+func Test_readFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(path, []byte("-----BEGIN CERTIFICATE-----\n"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	if got := readFile(path); got != "-----BEGIN CERTIFICATE-----\n" {
+		t.Errorf("readFile(%q) = %q", path, got)
+	}
+
+	t.Run("relative path", func(t *testing.T) {
+		t.Chdir(dir)
+		if got := readFile("cert.pem"); got != "-----BEGIN CERTIFICATE-----\n" {
+			t.Errorf("readFile(%q) = %q", "cert.pem", got)
 		}
-		f.Write([]byte(testString))
-		f.Close()
+	})
 
-		fileInfo, err := os.Stat(defaultPath)
-		t.Logf("permissions of defaultPath is: %o", fileInfo.Mode())
-		if err != nil {
-			t.Fatalf("failed to stat default file: %v", err)
+	t.Run("within limit", func(t *testing.T) {
+		if got := readFile(path, 28); len(got) != 28 {
+			t.Errorf("readFile with limit returned %d bytes, want 28", len(got))
 		}
+	})
 
-		fileExistOrDefault(destination, defaultPath)
-		// read file data
-		data, err := os.ReadFile(destination)
-		if err != nil {
-			t.Fatalf("failed to read file: %v", err)
+	t.Run("over limit", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("readFile did not panic for file over limit")
+			}
+		}()
+		readFile(path, 10)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("readFile did not panic for missing file")
+			}
+		}()
+		readFile(filepath.Join(dir, "missing.pem"))
+	})
+}
+
+func Test_changedSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.conf")
+	sumPath := path + ".sum"
+
+	t.Run("first run", func(t *testing.T) {
+		if !changedSince(path, "v1") {
+			t.Errorf("changedSince should return true when no checksum file exists")
 		}
-		if string(data) != testString {
-			t.Fatalf("expected file content to be '%s', got '%s'", string(data), testString)
+		if _, err := os.Stat(sumPath); err != nil {
+			t.Fatalf("checksum file was not written: %v", err)
 		}
+	})
 
-		// check file permissions
-		info, err := os.Stat(destination)
-		if err != nil {
-			t.Fatalf("failed to stat file: %v", err)
+	t.Run("unchanged content", func(t *testing.T) {
+		if changedSince(path, "v1") {
+			t.Errorf("changedSince should return false for unchanged content")
 		}
-		if info.Mode() != fileInfo.Mode() {
-			t.Fatalf("expected file permissions to be %o, got %o", fileInfo.Mode(), info.Mode())
+	})
+
+	t.Run("changed content", func(t *testing.T) {
+		before, _ := os.ReadFile(sumPath)
+		if !changedSince(path, "v2") {
+			t.Errorf("changedSince should return true for changed content")
+		}
+		after, _ := os.ReadFile(sumPath)
+		if string(before) == string(after) {
+			t.Errorf("checksum file was not updated")
+		}
+		if changedSince(path, "v2") {
+			t.Errorf("changedSince should return false after the checksum was updated")
 		}
 	})
 
-	t.Run("destination file not exist exists", func(t *testing.T) {
-		dir := t.TempDir()
-		destination := filepath.Join(dir, "testfile.txt")
-		defaultPath := filepath.Join(dir, "defaultPath.txt")
+	t.Run("unwritable checksum file", func(t *testing.T) {
 		defer func() {
 			if r := recover(); r == nil {
-				t.Errorf("fileExistOrDefault did not panic for non-existent destination file")
+				t.Errorf("changedSince did not panic for unwritable checksum file")
 			}
 		}()
-
-		fileExistOrDefault(destination, defaultPath)
+		changedSince(filepath.Join(t.TempDir(), "missing", "app.conf"), "v1")
 	})
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ANSI escape sequences used to highlight template excerpts
+const (
+	colorGutter = "\x1b[34m"
+	colorCaret  = "\x1b[1;31m"
+	colorReset  = "\x1b[0m"
+)
+
+// templateError is a template file that failed to parse or execute, it keeps the source of the
+// template so the failing line can be shown
+type templateError struct {
+	name   string
+	source string
+	err    error
+}
+
+func (e *templateError) Error() string {
+	return e.err.Error()
+}
+
+func (e *templateError) Unwrap() error {
+	return e.err
+}
+
+// position returns the line and the zero based byte column of the failure in the template.
+// The column is -1 for parse errors, which only name the line, and the line is 0 if the
+// error does not point into this template, for example into a string rendered by tpl.
+func (e *templateError) position() (line, column int) {
+	message := e.err.Error()
+	// an execution error carries the position of the failing node
+	var execErr template.ExecError
+	if errors.As(e.err, &execErr) {
+		message = execErr.Error()
+	}
+
+	// text/template reports "template: name:line:col:", html/template "html/template:name:line:col:"
+	pattern := regexp.MustCompile(`template: ?` + regexp.QuoteMeta(e.name) + `:(\d+):(?:(\d+):)?`)
+	match := pattern.FindStringSubmatch(message)
+	if match == nil {
+		return 0, -1
+	}
+	line, _ = strconv.Atoi(match[1])
+	column = -1
+	if match[2] != "" {
+		column, _ = strconv.Atoi(match[2])
+	}
+	return line, column
+}
+
+// excerpt returns the failing line of the template prefixed with its number and, if the column is
+// known, followed by a caret under it. It returns an empty string if the position is unknown.
+func (e *templateError) excerpt(color bool) string {
+	line, column := e.position()
+	lines := strings.Split(e.source, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	text := strings.TrimRight(lines[line-1], "\r")
+
+	number := strconv.Itoa(line)
+	gutter := strings.Repeat(" ", len(number)) + " | "
+	caret := "^"
+	if color {
+		gutter = colorGutter + gutter + colorReset
+		caret = colorCaret + caret + colorReset
+	}
+
+	var b strings.Builder
+	if color {
+		fmt.Fprintf(&b, "%s%s | %s%s\n", colorGutter, number, colorReset, text)
+	} else {
+		fmt.Fprintf(&b, "%s | %s\n", number, text)
+	}
+	if column < 0 {
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	column = min(column, len(text))
+	b.WriteString(gutter)
+	// keep tabs so the caret lines up with the text above it
+	for _, r := range text[:column] {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(caret)
+	return b.String()
+}
+
+// withExcerpts appends the excerpt of the failing template line to an error caused by a templateError.
+// Each error joined with errors.Join gets its own excerpt. Other errors are returned unchanged.
+func withExcerpts(err error, color bool) error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		result := make([]error, len(errs))
+		for i, e := range errs {
+			result[i] = withExcerpts(e, color)
+		}
+		return errors.Join(result...)
+	}
+	var te *templateError
+	if !errors.As(err, &te) {
+		return err
+	}
+	excerpt := te.excerpt(color)
+	if excerpt == "" {
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, excerpt)
+}
+
+// useColor resolves a --color mode of always, never or auto. In auto mode color is used when
+// stderr is a terminal and NO_COLOR is not set.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		f, ok := stderr.(*os.File)
+		if !ok || os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("color '%s' must be one of auto, always or never", mode)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTemplateErrorExcerpt(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		opts   Options
+		wanted string
+	}{
+		{
+			name:   "execution error",
+			source: "server {\n  listen {{ asPort \"PORT\" }};\n}",
+			wanted: "2 |   listen {{ asPort \"PORT\" }};\n  |             ^",
+		},
+		{
+			name:   "tabs are kept",
+			source: "\tlisten {{ asPort \"PORT\" }};",
+			wanted: "1 | \tlisten {{ asPort \"PORT\" }};\n  | \t          ^",
+		},
+		{
+			name:   "parse error has no column",
+			source: "a\nb\n{{ nope }}",
+			wanted: "3 | {{ nope }}",
+		},
+		{
+			name:   "html mode",
+			source: "<p>{{ asString \"MISSING\" }}</p>",
+			opts:   Options{HTML: true},
+			wanted: "1 | <p>{{ asString \"MISSING\" }}</p>\n  |       ^",
+		},
+		{
+			name:   "error inside tpl points at the call",
+			source: "{{ tpl \"{{ asString \\\"MISSING\\\" }}\" }}",
+			wanted: "1 | {{ tpl \"{{ asString \\\"MISSING\\\" }}\" }}\n  |    ^",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := RenderTemplateWithOptionsTo(&strings.Builder{}, "test.tmpl", tc.source, Environment{"PORT": "nope"}, tc.opts)
+			if err == nil {
+				t.Fatalf("Expected error but got none")
+			}
+			te := &templateError{name: "test.tmpl", source: tc.source, err: err}
+			if got := te.excerpt(false); got != tc.wanted {
+				t.Errorf("excerpt() = %q, want %q", got, tc.wanted)
+			}
+		})
+	}
+}
+
+func TestTemplateErrorExcerptColor(t *testing.T) {
+	source := "{{ asString \"MISSING\" }}"
+	err := RenderTemplateWithOptionsTo(&strings.Builder{}, "test.tmpl", source, Environment{}, Options{})
+	te := &templateError{name: "test.tmpl", source: source, err: err}
+
+	got := te.excerpt(true)
+	if !strings.Contains(got, colorCaret+"^"+colorReset) {
+		t.Errorf("Expected a colored caret but got %q", got)
+	}
+	if strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(got, colorGutter, ""), colorCaret, ""), colorReset, "") != te.excerpt(false) {
+		t.Errorf("Expected colors to only add escape sequences but got %q", got)
+	}
+}
+
+func TestWithExcerpts(t *testing.T) {
+	plain := errors.New("plain")
+	if got := withExcerpts(plain, false); got != plain {
+		t.Errorf("Expected other errors to be returned unchanged but got %v", got)
+	}
+	if withExcerpts(nil, false) != nil {
+		t.Errorf("Expected nil to stay nil")
+	}
+
+	other := &templateError{name: "a", source: "x", err: errors.New("template: b:1:2: executing \"b\"")}
+	if got := withExcerpts(other, false); got != error(other) {
+		t.Errorf("Expected an error pointing into another template to be returned unchanged but got %v", got)
+	}
+
+	a := &templateError{name: "a", source: "x\n{{ nope }}", err: errors.New("template: a:2: function \"nope\" not defined")}
+	b := &templateError{name: "b", source: "{{ nope }}", err: errors.New("template: b:1: function \"nope\" not defined")}
+	got := withExcerpts(errors.Join(a, plain, b), false)
+	wanted := "template: a:2: function \"nope\" not defined\n2 | {{ nope }}\nplain\ntemplate: b:1: function \"nope\" not defined\n1 | {{ nope }}"
+	if got.Error() != wanted {
+		t.Errorf("withExcerpts() = %q, want %q", got.Error(), wanted)
+	}
+	if !errors.Is(got, plain) {
+		t.Errorf("Expected the joined errors to be kept")
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wanted  bool
+		wantErr bool
+	}{
+		{mode: "always", wanted: true},
+		{mode: "never", wanted: false},
+		// stderr is not a terminal in tests
+		{mode: "auto", wanted: false},
+		{mode: "sometimes", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			got, err := useColor(tc.mode)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("useColor(%q) error = %v, want error %v", tc.mode, err, tc.wantErr)
+			}
+			if got != tc.wanted {
+				t.Errorf("useColor(%q) = %v, want %v", tc.mode, got, tc.wanted)
+			}
+		})
+	}
+}
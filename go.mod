@@ -1,3 +1,10 @@
 module github.com/aasaam/zep
 
-go 1.24
+go 1.24.0
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/Masterminds/semver/v3 v3.5.0
+	golang.org/x/crypto v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
+)
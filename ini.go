@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// fromIni parses INI content into sections of key value pairs. Keys before the first [section]
+// header belong to the default section "". Lines starting with ; or # are comments, inline comments
+// are not recognised and stay part of the value. Keys and values are trimmed and one pair of double
+// quotes around a value is removed, a repeated section is merged and a repeated key keeps its last value
+// Panics on a line that is neither a comment, a section header nor a key=value pair
+func fromIni(s string) map[string]map[string]string {
+	result := map[string]map[string]string{}
+	section := ""
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				panic(fmt.Errorf("could not parse ini line %d: unterminated section header '%s'", i+1, line))
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := result[section]; !ok {
+				result[section] = map[string]string{}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			panic(fmt.Errorf("could not parse ini line %d: expected key=value, got '%s'", i+1, line))
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		if _, ok := result[section]; !ok {
+			result[section] = map[string]string{}
+		}
+		result[section][key] = value
+	}
+	return result
+}
+
+// toIni serializes sections of key value pairs as INI content that fromIni reads back unchanged.
+// The default section "" comes first without a header, the other sections and all keys are sorted.
+// Values with surrounding whitespace or a leading double quote are written in double quotes
+// Panics if a section, key or value cannot be represented, for example because it contains a newline
+func toIni(v map[string]map[string]string) string {
+	var sb strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(v)) {
+		if name != "" {
+			if strings.ContainsAny(name, "]\r\n") || name != strings.TrimSpace(name) {
+				panic(fmt.Errorf("could not serialize ini section '%s'", name))
+			}
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "[%s]\n", name)
+		}
+		for _, key := range sortedKeys(v[name]) {
+			value := v[name][key]
+			if key == "" || key != strings.TrimSpace(key) || strings.ContainsAny(key, "=\r\n") || strings.ContainsAny(key[:1], "[;#") {
+				panic(fmt.Errorf("could not serialize ini key '%s' in section '%s'", key, name))
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				panic(fmt.Errorf("could not serialize the value of ini key '%s' in section '%s': it contains a line break", key, name))
+			}
+			if value != strings.TrimSpace(value) || strings.HasPrefix(value, `"`) {
+				value = `"` + value + `"`
+			}
+			sb.WriteString(strings.TrimRight(key+" = "+value, " ") + "\n")
+		}
+	}
+	return sb.String()
+}
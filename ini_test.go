@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_fromIni(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wanted    map[string]map[string]string
+		wantPanic bool
+	}{
+		{
+			name:  "sections and default section",
+			value: "name = app\n\n[server]\nhost = localhost\nport=8080\n\n[database]\nurl = postgres://db/app?sslmode=disable\n",
+			wanted: map[string]map[string]string{
+				"":         {"name": "app"},
+				"server":   {"host": "localhost", "port": "8080"},
+				"database": {"url": "postgres://db/app?sslmode=disable"},
+			},
+		},
+		{
+			name:  "comments",
+			value: "; comment\n# another\n[server]\n  ; indented comment\nhost = localhost ; not a comment\n",
+			wanted: map[string]map[string]string{
+				"server": {"host": "localhost ; not a comment"},
+			},
+		},
+		{
+			name:  "quoted values",
+			value: "padded = \"  x  \"\nempty = \"\"\nbare =\nquote = \"",
+			wanted: map[string]map[string]string{
+				"": {"padded": "  x  ", "empty": "", "bare": "", "quote": `"`},
+			},
+		},
+		{
+			name:  "repeated section and key",
+			value: "[a]\nx = 1\n[b]\ny = 2\n[a]\nx = 3\nz = 4\n",
+			wanted: map[string]map[string]string{
+				"a": {"x": "3", "z": "4"},
+				"b": {"y": "2"},
+			},
+		},
+		{
+			name:  "empty section and crlf",
+			value: "[empty]\r\n[ spaced ]\r\nkey = value\r\n",
+			wanted: map[string]map[string]string{
+				"empty":  {},
+				"spaced": {"key": "value"},
+			},
+		},
+		{name: "empty", value: "", wanted: map[string]map[string]string{}},
+		{name: "missing equals", value: "[a]\nflag\n", wantPanic: true},
+		{name: "empty key", value: "= value\n", wantPanic: true},
+		{name: "unterminated section", value: "[a\n", wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.wantPanic {
+					t.Errorf("fromIni(%q) panic = %v, want panic %v", tc.value, r, tc.wantPanic)
+				}
+			}()
+			got := fromIni(tc.value)
+			if !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("fromIni(%q) = %v, want %v", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_toIni(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     map[string]map[string]string
+		wanted    string
+		wantPanic bool
+	}{
+		{
+			name: "sections sorted after default section",
+			value: map[string]map[string]string{
+				"server": {"port": "8080", "host": "localhost"},
+				"":       {"name": "app"},
+				"a":      {"x": "1"},
+			},
+			wanted: "name = app\n\n[a]\nx = 1\n\n[server]\nhost = localhost\nport = 8080\n",
+		},
+		{
+			name:   "quoting",
+			value:  map[string]map[string]string{"s": {"padded": " x ", "quoted": `"a"`, "empty": "", "inner": `a "b" c`}},
+			wanted: "[s]\nempty =\ninner = a \"b\" c\npadded = \" x \"\nquoted = \"\"a\"\"\n",
+		},
+		{name: "empty section", value: map[string]map[string]string{"empty": {}}, wanted: "[empty]\n"},
+		{name: "empty", value: map[string]map[string]string{}, wanted: ""},
+		{name: "newline in value", value: map[string]map[string]string{"s": {"k": "a\nb"}}, wantPanic: true},
+		{name: "equals in key", value: map[string]map[string]string{"s": {"a=b": "c"}}, wantPanic: true},
+		{name: "comment key", value: map[string]map[string]string{"s": {";k": "c"}}, wantPanic: true},
+		{name: "bracket in section", value: map[string]map[string]string{"a]": {"k": "v"}}, wantPanic: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.wantPanic {
+					t.Errorf("toIni(%v) panic = %v, want panic %v", tc.value, r, tc.wantPanic)
+				}
+			}()
+			got := toIni(tc.value)
+			if got != tc.wanted {
+				t.Errorf("toIni(%v) = %q, want %q", tc.value, got, tc.wanted)
+			}
+			if back := fromIni(got); !reflect.DeepEqual(back, tc.value) {
+				t.Errorf("fromIni(toIni(%v)) = %v", tc.value, back)
+			}
+		})
+	}
+
+	env := Environment{"CONFIG": "[server]\nhost = localhost\nport = 80\n"}
+	got, err := RenderTemplate(`{{ $ini := asString "CONFIG" | fromIni }}{{ index $ini.server "port" }}|{{ toIni $ini }}`, env)
+	if err != nil || got != "80|[server]\nhost = localhost\nport = 80\n" {
+		t.Errorf("fromIni and toIni in template = %q, %v", got, err)
+	}
+}
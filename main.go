@@ -1,15 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
 )
 
 func main() {
-	output, err := Run(os.Args, os.Environ())
+	stdout := bufio.NewWriter(os.Stdout)
+	err := RunTo(stdout, os.Args, os.Environ())
+	stdout.Flush()
+	if errors.Is(err, errDiffFound) {
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Fprintln(os.Stdout, output)
 }
@@ -1,37 +1,421 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-// Run executes the template rendering process.
+// stderr receives warnings and the log of -watch, tests replace it to capture the output
+var stderr io.Writer = os.Stderr
+
+// errDiffFound is returned by RunTo in --diff mode when the rendered output differs from the output file
+var errDiffFound = errors.New("rendered output differs from the output file")
+
+// Run executes the template rendering process and returns the output instead of writing it.
+// The newline RunTo appends after the output is left out, the output itself is returned as is.
 func Run(args []string, environ []string) (string, error) {
-	if len(args) != 2 {
-		return "", fmt.Errorf("usage: %s <template-file>", args[0])
+	var buf strings.Builder
+	var appended bool
+	if err := runTo(&buf, args, environ, &appended); err != nil {
+		return "", err
+	}
+	if appended {
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+	return buf.String(), nil
+}
+
+// RunTo executes the template rendering process and writes the output, followed by a newline, to stdout.
+// With --no-newline, --ensure-newline or --no-trailing-newline a rendered template is written exactly as post-processed.
+// A file written with -o never gets the extra newline.
+// A rendered template is streamed to stdout or to the -o file instead of being held in memory.
+func RunTo(stdout io.Writer, args []string, environ []string) error {
+	return runTo(stdout, args, environ, new(bool))
+}
+
+// runTo implements RunTo, it sets appended if a newline was written after the output.
+func runTo(stdout io.Writer, args []string, environ []string, appended *bool) error {
+	opts := Options{}
+	var backupSuffix, colorMode, stripPrefix, profile, schemaFile, srcDir, outDir, outputFile string
+	var watch, dryRun, showDiff, backup, exportEnv, noNewline, warnMalformed, expand, expandStrict, help, showVersion bool
+	var interval time.Duration
+	var sets setFlag
+
+	fs := flag.NewFlagSet(filepath.Base(args[0]), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&outputFile, "output", "", "write the output to `file` instead of stdout")
+	fs.StringVar(&outputFile, "o", "", "shorthand for -output `file`")
+	fs.StringVar(&srcDir, "dir", "", "render every *.tmpl file below `directory`")
+	fs.StringVar(&outDir, "out", "", "output `directory` for -dir")
+	fs.BoolVar(&backup, "backup", false, "copy an existing -o file to a backup before replacing it")
+	fs.StringVar(&backupSuffix, "backup-suffix", ".bak", "`suffix` appended to the -o file name for -backup")
+	fs.BoolVar(&watch, "watch", false, "re-render when the template changes, requires -o")
+	fs.BoolVar(&showDiff, "diff", false, "print a unified diff against the -o file instead of writing it, fail if they differ")
+	fs.BoolVar(&opts.ContinueOnError, "continue-on-error", false, "with -dir, render the remaining templates after a failure and report all failures")
+	fs.BoolVar(&dryRun, "dry-run", false, "render and report errors without writing any output")
+	fs.DurationVar(&interval, "interval", time.Second, "polling `interval` for -watch")
+	fs.BoolVar(&exportEnv, "export-env", false, "write the resolved environment as a .env file instead of rendering a template")
+	fs.BoolVar(&warnMalformed, "warn-malformed", false, "warn about malformed and duplicate environment entries on stderr")
+	fs.StringVar(&stripPrefix, "strip-prefix", "", "make variables starting with `prefix` also available without it")
+	fs.StringVar(&profile, "profile", "", "let <name>_X variables override X for profile `name`")
+	fs.Var(&sets, "set", "set `path=value` in .Values, such as a.b=1, may be repeated")
+	fs.BoolVar(&expand, "expand", false, "resolve ${VAR} and $VAR references inside values, undefined ones become empty")
+	fs.BoolVar(&expandStrict, "expand-strict", false, "like -expand but fail on undefined references")
+	fs.StringVar(&schemaFile, "schema", "", "validate the environment against the YAML schema `file` before rendering")
+	fs.BoolVar(&opts.FailOnEmpty, "fail-on-empty", false, "treat empty or whitespace only values as missing")
+	fs.BoolVar(&opts.HTML, "html", false, "use html/template contextual auto-escaping")
+	fs.BoolVar(&opts.Trim, "trim", false, "strip leading and trailing whitespace from the output")
+	fs.BoolVar(&opts.TrimBlankLines, "trim-blank-lines", false, "collapse runs of blank lines in the output into one")
+	fs.BoolVar(&opts.EnsureNewline, "ensure-newline", false, "make the output end with exactly one newline")
+	fs.BoolVar(&opts.NoTrailingNewline, "no-trailing-newline", false, "remove all newlines from the end of the output")
+	fs.BoolVar(&noNewline, "no-newline", false, "write the rendered output to stdout as is, without appending a newline")
+	fs.BoolVar(&noNewline, "n", false, "shorthand for -no-newline")
+	fs.BoolVar(&opts.Chomp, "chomp", false, "drop the lines of standalone control actions like {{ if }} and {{ end }}")
+	fs.BoolVar(&opts.AnnotateSource, "annotate-source", false, "prefix output lines with the template that produced them")
+	fs.BoolVar(&opts.IncludeEnvComments, "include-env-comments", false, "make withSource append a comment naming the key")
+	fs.StringVar(&opts.Banner, "banner", "", "prepend the rendered `template` to the output as comment lines")
+	fs.StringVar(&opts.CommentPrefix, "annotate-comment", "#", "comment `syntax` for annotations and -banner")
+	fs.StringVar(&opts.CommentPrefix, "comment-prefix", "#", "alias of -annotate-comment `syntax`")
+	fs.StringVar(&colorMode, "color", "auto", "highlight the failing template line of errors: `auto`, always or never")
+	fs.BoolVar(&help, "help", false, "show this help")
+	fs.BoolVar(&help, "h", false, "shorthand for -help")
+	fs.BoolVar(&showVersion, "version", false, "show version and build information")
+
+	// flags may appear before and after the template file
+	var files []string
+	remaining := args[1:]
+	for {
+		if err := fs.Parse(remaining); err != nil {
+			return err
+		}
+		remaining = fs.Args()
+		if len(remaining) == 0 {
+			break
+		}
+		files = append(files, remaining[0])
+		remaining = remaining[1:]
+	}
+
+	if help {
+		*appended = true
+		return writeLine(stdout, helpText(fs))
+	}
+	if showVersion {
+		*appended = true
+		return writeLine(stdout, versionString())
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval '%s' must be positive", interval)
+	}
+	color, err := useColor(colorMode)
+	if err != nil {
+		return err
 	}
 
-	templateFile := args[1]
+	usage := fmt.Errorf("usage: %s [options] <template-file>\nrun '%s --help' for details", args[0], args[0])
+	if (srcDir != "" || outDir != "") && (srcDir == "" || outDir == "" || len(files) != 0 || outputFile != "" || watch) {
+		return usage
+	}
+	if exportEnv && (len(files) != 0 || srcDir != "" || outDir != "" || watch || showDiff || dryRun || backup || opts.ContinueOnError) {
+		return usage
+	}
+	if !exportEnv && srcDir == "" && (len(files) != 1 || opts.ContinueOnError) {
+		return usage
+	}
+	if watch && (outputFile == "" || dryRun) {
+		return usage
+	}
+	if showDiff && (outputFile == "" || watch) {
+		return usage
+	}
+	if backup && (outputFile == "" || backupSuffix == "") {
+		return usage
+	}
+	if opts.EnsureNewline && opts.NoTrailingNewline {
+		return usage
+	}
+	if backup {
+		opts.BackupSuffix = backupSuffix
+	}
+	if len(sets) > 0 {
+		values, err := parseSetValues(sets)
+		if err != nil {
+			return err
+		}
+		opts.Values = values
+	}
+
+	var warnings io.Writer
+	if warnMalformed {
+		warnings = stderr
+	}
+	// the prefix is stripped first, so with -strip-prefix APP_ -profile PROD, APP_PROD_X shadows X
+	env := NewEnvironmentWithProfile(NewEnvironmentWithPrefix(parseEnviron(environ, warnings), stripPrefix), profile)
+	if expand || expandStrict {
+		expanded, err := env.Expand(expandStrict)
+		if err != nil {
+			return fmt.Errorf("error expanding environment: %v", err)
+		}
+		env = expanded
+	}
+	if schemaFile != "" {
+		schema, err := LoadSchema(schemaFile)
+		if err != nil {
+			return err
+		}
+		validated := env
+		if opts.FailOnEmpty {
+			validated = env.WithoutEmpty()
+		}
+		if err := schema.Validate(validated); err != nil {
+			return fmt.Errorf("environment does not match schema '%s':\n%v", schemaFile, err)
+		}
+	}
+
+	if exportEnv {
+		if opts.FailOnEmpty {
+			env = env.WithoutEmpty()
+		}
+		return exportDotEnv(stdout, outputFile, env)
+	}
+
+	if srcDir != "" {
+		written, err := renderDirectory(srcDir, outDir, env, opts, !dryRun)
+		err = withExcerpts(err, color)
+		if err != nil && !opts.ContinueOnError {
+			return fmt.Errorf("error rendering directory: %v", err)
+		}
+		if !dryRun {
+			*appended = true
+			if err := writeLine(stdout, strings.Join(written, "\n")); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error rendering directory:\n%v", err)
+		}
+		return nil
+	}
+
+	templateFile := files[0]
+
+	if watch {
+		return Watch(context.Background(), templateFile, outputFile, env, opts, interval, stderr)
+	}
+
+	// the newline options decide how the output ends
+	appendNewline := !noNewline && !opts.EnsureNewline && !opts.NoTrailingNewline
+	newline, err := renderFile(stdout, templateFile, outputFile, env, opts, dryRun, showDiff, appendNewline)
+	*appended = newline
+	return withExcerpts(err, color)
+}
+
+// renderFile renders templateFile to outputFile or, without one, to stdout, followed by a newline
+// if appendNewline is set and the output is not empty. With dryRun the output is discarded and
+// with showDiff it is compared to outputFile. It reports whether the newline was written.
+func renderFile(stdout io.Writer, templateFile, outputFile string, env Environment, opts Options, dryRun, showDiff, appendNewline bool) (bool, error) {
+	if showDiff {
+		return false, diffFile(stdout, templateFile, outputFile, env, opts)
+	}
 
+	if dryRun {
+		return false, renderFileTo(io.Discard, templateFile, env, opts)
+	}
+
+	if outputFile != "" {
+		return false, renderToFile(templateFile, outputFile, env, opts)
+	}
+
+	cw := &countingWriter{w: stdout}
+	if err := renderFileTo(cw, templateFile, env, opts); err != nil {
+		return false, err
+	}
+	if cw.n == 0 || !appendNewline {
+		return false, nil
+	}
+	_, err := io.WriteString(stdout, "\n")
+	return err == nil, err
+}
+
+// parseEnviron turns KEY=value entries into a map. Entries without = are dropped and
+// the last of duplicate keys wins; if warnings is not nil, both are reported to it.
+func parseEnviron(environ []string, warnings io.Writer) map[string]string {
 	envMap := make(map[string]string)
 	for _, e := range environ {
 		pair := strings.SplitN(e, "=", 2)
-		if len(pair) == 2 {
-			envMap[pair[0]] = pair[1]
+		if len(pair) != 2 {
+			if warnings != nil {
+				fmt.Fprintf(warnings, "warning: ignoring malformed environment entry '%s'\n", e)
+			}
+			continue
+		}
+		if _, ok := envMap[pair[0]]; ok && warnings != nil {
+			fmt.Fprintf(warnings, "warning: duplicate environment variable '%s', using the last value\n", pair[0])
 		}
+		envMap[pair[0]] = pair[1]
 	}
-	env := NewEnvironment(envMap)
+	return envMap
+}
+
+// helpText returns the synopsis, the options of the flag set and template examples.
+func helpText(fs *flag.FlagSet) string {
+	var options strings.Builder
+	fs.SetOutput(&options)
+	fs.PrintDefaults()
+	fs.SetOutput(io.Discard)
+
+	return strings.ReplaceAll(`zep renders Go templates using environment variables.
+
+Usage:
+  PROGRAM [options] <template-file>
+  PROGRAM [options] --watch [--interval <duration>] -o <output-file> <template-file>
+  PROGRAM [options] --dir <template-dir> --out <output-dir>
+  PROGRAM [options] --export-env [-o <output-file>]
 
+Options:
+`, "PROGRAM", fs.Name()) + options.String() + `
+Examples:
+  listen {{ asPortOr "PORT" 8080 }};
+  {{ range asStringSliceTrim "HOSTS" "," " " }}
+  server {{ . }};
+  {{ end }}
+  {{ if asBoolOr "DEBUG" false }}log_level debug;{{ end }}`
+}
+
+// writeLine writes a non-empty message followed by a newline to w.
+func writeLine(w io.Writer, message string) error {
+	if message == "" {
+		return nil
+	}
+	_, err := fmt.Fprintln(w, message)
+	return err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// renderFileTo reads a single template file and streams the rendered output to w.
+func renderFileTo(w io.Writer, templateFile string, env Environment, opts Options) error {
 	templateContent, err := os.ReadFile(templateFile)
 	if err != nil {
-		return "", fmt.Errorf("error reading template file '%s': %v", templateFile, err)
+		return fmt.Errorf("error reading template file '%s': %v", templateFile, err)
+	}
+
+	name := filepath.Base(templateFile)
+	opts.BaseDir = filepath.Dir(templateFile)
+	if err := RenderTemplateWithOptionsTo(w, name, string(templateContent), env, opts); err != nil {
+		return fmt.Errorf("error rendering template: %w", &templateError{name: name, source: string(templateContent), err: err})
+	}
+
+	return nil
+}
+
+// exportDotEnv writes env as a .env file to outputFile or, without one, to stdout.
+// The output file is created readable by the owner only since the environment may hold secrets.
+func exportDotEnv(stdout io.Writer, outputFile string, env Environment) error {
+	if outputFile == "" {
+		return env.WriteDotEnv(stdout, stderr)
+	}
+	var buf strings.Builder
+	if err := env.WriteDotEnv(&buf, stderr); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputFile, []byte(buf.String()), 0600); err != nil {
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
+	}
+	return nil
+}
+
+// diffFile renders templateFile in memory and writes a unified diff against outputFile to w.
+// A missing outputFile is compared as empty. It returns errDiffFound if the two differ.
+func diffFile(w io.Writer, templateFile, outputFile string, env Environment, opts Options) error {
+	var rendered strings.Builder
+	if err := renderFileTo(&rendered, templateFile, env, opts); err != nil {
+		return err
+	}
+	current, err := os.ReadFile(outputFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading output file '%s': %v", outputFile, err)
+	}
+
+	diff, err := unifiedDiff(outputFile, outputFile+" (rendered)", string(current), rendered.String())
+	if err != nil {
+		return fmt.Errorf("error comparing with output file '%s': %v", outputFile, err)
+	}
+	if diff == "" {
+		return nil
+	}
+	if _, err := io.WriteString(w, diff); err != nil {
+		return err
+	}
+	return errDiffFound
+}
+
+// renderToFile renders templateFile and streams the result to outputFile.
+// The output is written to a temporary file next to outputFile that atomically replaces it by a rename
+// only once rendering succeeded, so readers never see a partial file and a failed or interrupted render
+// keeps the previous output intact. The temporary file is removed on error. With opts.BackupSuffix set, an existing outputFile
+// is copied to outputFile+opts.BackupSuffix with its mode before it is replaced.
+func renderToFile(templateFile, outputFile string, env Environment, opts Options) error {
+	perm := os.FileMode(0644)
+	info, err := os.Stat(outputFile)
+	exists := err == nil
+	if exists {
+		perm = info.Mode().Perm()
 	}
 
-	output, err := RenderTemplate(string(templateContent), env)
+	tmp, err := os.CreateTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".*")
 	if err != nil {
-		return "", fmt.Errorf("error rendering template: %v", err)
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
 	}
+	defer os.Remove(tmp.Name())
 
-	return output, nil
+	out := bufio.NewWriter(tmp)
+	if err := renderFileTo(out, templateFile, env, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
+	}
+	// make sure the content is on disk before it replaces the output file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
+	}
+	if exists && opts.BackupSuffix != "" {
+		backupFile := outputFile + opts.BackupSuffix
+		if err := copyFile(outputFile, backupFile, perm); err != nil {
+			return fmt.Errorf("error backing up output file: %v", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), outputFile); err != nil {
+		return fmt.Errorf("error writing output file '%s': %v", outputFile, err)
+	}
+	return nil
 }
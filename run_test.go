@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -65,6 +68,65 @@ func TestRun(t *testing.T) {
 			expectedOutput:  "a b c ",
 			expectError:     false,
 		},
+		{
+			name:            "HTML escaping",
+			args:            []string{"zep", "--html", "template.txt"},
+			env:             []string{"NAME=<World>"},
+			templateFile:    "template.txt",
+			templateContent: "<p>Hello {{.NAME}}</p>",
+			expectedOutput:  "<p>Hello &lt;World&gt;</p>",
+			expectError:     false,
+		},
+		{
+			name:            "Profile override",
+			args:            []string{"zep", "--profile", "PROD", "template.txt"},
+			env:             []string{"DB_HOST=localhost", "PROD_DB_HOST=db.prod", "DB_PORT=5432"},
+			templateFile:    "template.txt",
+			templateContent: "{{ asString \"DB_HOST\" }}:{{ asString \"DB_PORT\" }}",
+			expectedOutput:  "db.prod:5432",
+			expectError:     false,
+		},
+		{
+			name:            "Profile override with equals",
+			args:            []string{"zep", "--profile=PROD", "template.txt"},
+			env:             []string{"DB_HOST=localhost", "PROD_DB_HOST=db.prod"},
+			templateFile:    "template.txt",
+			templateContent: "{{ .DB_HOST }}",
+			expectedOutput:  "db.prod",
+			expectError:     false,
+		},
+		{
+			name:            "Strip prefix",
+			args:            []string{"zep", "--strip-prefix", "APP_", "template.txt"},
+			env:             []string{"APP_PORT=8080", "PORT=80"},
+			templateFile:    "template.txt",
+			templateContent: "{{ .PORT }}",
+			expectedOutput:  "8080",
+			expectError:     false,
+		},
+		{
+			name:            "Strip prefix before profile",
+			args:            []string{"zep", "--strip-prefix=APP_", "--profile", "PROD", "template.txt"},
+			env:             []string{"APP_PORT=8080", "APP_PROD_PORT=443"},
+			templateFile:    "template.txt",
+			templateContent: "{{ .PORT }}",
+			expectedOutput:  "443",
+			expectError:     false,
+		},
+		{
+			name:        "Profile without value",
+			args:        []string{"zep", "--profile"},
+			env:         []string{},
+			expectError: true,
+		},
+		{
+			name:            "Fail on empty",
+			args:            []string{"zep", "--fail-on-empty", "template.txt"},
+			env:             []string{"NAME= "},
+			templateFile:    "template.txt",
+			templateContent: "Hello {{ asString \"NAME\" }}",
+			expectError:     true,
+		},
 		{
 			name:        "Missing template file",
 			args:        []string{"zep", "nonexistent.txt"},
@@ -98,7 +160,7 @@ func TestRun(t *testing.T) {
 				}
 
 				if len(tc.args) > 1 {
-					tc.args[1] = templatePath
+					tc.args[len(tc.args)-1] = templatePath
 				}
 			}
 
@@ -167,3 +229,340 @@ func TestRunWithMalformedEnvironment(t *testing.T) {
 		t.Errorf("Expected output %q with malformed environment but got %q", expectedOutput, output)
 	}
 }
+
+func TestRunWarnMalformed(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	if err := os.WriteFile(templatePath, []byte("Hello {{ .NAME }}!"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	var captured strings.Builder
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = &captured
+
+	environ := []string{"NAME=First", "INVALID_ENTRY", "NAME=World"}
+	output, err := Run([]string{"zep", templatePath}, environ)
+	if err != nil || output != "Hello World!" {
+		t.Fatalf("Unexpected result %q, %v", output, err)
+	}
+	if captured.String() != "" {
+		t.Errorf("Expected no warnings by default but got %q", captured.String())
+	}
+
+	output, err = Run([]string{"zep", "--warn-malformed", templatePath}, environ)
+	if err != nil || output != "Hello World!" {
+		t.Fatalf("Unexpected result %q, %v", output, err)
+	}
+	want := "warning: ignoring malformed environment entry 'INVALID_ENTRY'\n" +
+		"warning: duplicate environment variable 'NAME', using the last value\n"
+	if captured.String() != want {
+		t.Errorf("Expected warnings %q but got %q", want, captured.String())
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	for _, flag := range []string{"--help", "-h"} {
+		t.Run(flag, func(t *testing.T) {
+			output, err := Run([]string{"zep", flag, "missing-template.txt"}, []string{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, want := range []string{"Usage:", "zep [options] <template-file>", "-output file", "-version", "Examples:"} {
+				if !contains(output, want) {
+					t.Errorf("Expected help to contain %q but got %q", want, output)
+				}
+			}
+		})
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	tempDir := t.TempDir()
+
+	templatePath := filepath.Join(tempDir, "template.txt")
+	err := os.WriteFile(templatePath, []byte("{{ .NAME }}"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	t.Run("flags after template file", func(t *testing.T) {
+		output, err := Run([]string{"zep", templatePath, "--html"}, []string{"NAME=<b>"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output != "&lt;b&gt;" {
+			t.Errorf("Expected output %q but got %q", "&lt;b&gt;", output)
+		}
+	})
+
+	t.Run("single dash flags", func(t *testing.T) {
+		output, err := Run([]string{"zep", "-profile", "DEV", templatePath}, []string{"NAME=prod", "DEV_NAME=dev"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output != "dev" {
+			t.Errorf("Expected output %q but got %q", "dev", output)
+		}
+	})
+
+	t.Run("expand", func(t *testing.T) {
+		environ := []string{"NAME=${FIRST} ${LAST}", "FIRST=Ada", "LAST=Lovelace"}
+		output, err := Run([]string{"zep", "--expand", templatePath}, environ)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output != "Ada Lovelace" {
+			t.Errorf("Expected output %q but got %q", "Ada Lovelace", output)
+		}
+
+		if _, err := Run([]string{"zep", "--expand-strict", templatePath}, environ[:2]); err == nil {
+			t.Errorf("Expected error for undefined reference but got none")
+		}
+	})
+
+	t.Run("version", func(t *testing.T) {
+		output, err := Run([]string{"zep", "--version"}, []string{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output != versionString() {
+			t.Errorf("Expected output %q but got %q", versionString(), output)
+		}
+	})
+
+	unknownFlags := [][]string{
+		{"zep", "--unknown", templatePath},
+		{"zep", templatePath, "-x"},
+		{"zep", "--html=maybe", templatePath},
+		{"zep", "--profile"},
+	}
+	for _, args := range unknownFlags {
+		t.Run(strings.Join(args[1:], " "), func(t *testing.T) {
+			_, err := Run(args, []string{})
+			if err == nil {
+				t.Errorf("Expected error for arguments %v but got none", args)
+			}
+		})
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "out.conf")
+	if err := os.WriteFile(templatePath, []byte("listen {{ asPort \"PORT\" }};"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	output, err := Run([]string{"zep", "--dry-run", "-o", outputPath, templatePath}, []string{"PORT=8080"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "" {
+		t.Errorf("Expected empty output but got %q", output)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected output file not to be written, stat returned %v", err)
+	}
+
+	if _, err := Run([]string{"zep", "--dry-run", "-o", outputPath, templatePath}, []string{"PORT=http"}); err == nil {
+		t.Errorf("Expected render error but got none")
+	}
+
+	srcDir := filepath.Join(tempDir, "src")
+	outDir := filepath.Join(tempDir, "rendered")
+	writeTestFiles(t, srcDir, map[string]string{"app.conf.tmpl": "port={{ asPort \"PORT\" }}", "static.txt": "x"})
+	output, err = Run([]string{"zep", "--dry-run", "--dir", srcDir, "--out", outDir}, []string{"PORT=8080"})
+	if err != nil || output != "" {
+		t.Errorf("Expected no output and no error but got %q, %v", output, err)
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Errorf("Expected output directory not to be created, stat returned %v", err)
+	}
+	if _, err := Run([]string{"zep", "--dry-run", "--dir", srcDir, "--out", outDir}, []string{}); err == nil {
+		t.Errorf("Expected render error for directory but got none")
+	}
+
+	if _, err := Run([]string{"zep", "--dry-run", "--watch", "-o", outputPath, templatePath}, []string{}); err == nil {
+		t.Errorf("Expected usage error for --dry-run with --watch but got none")
+	}
+}
+
+func TestRunBackup(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "app.conf")
+	if err := os.WriteFile(templatePath, []byte("version {{ asString \"VERSION\" }}"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	// no existing file, nothing to back up
+	if _, err := Run([]string{"zep", "--backup", "-o", outputPath, templatePath}, []string{"VERSION=1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(outputPath + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("Expected no backup file, stat returned %v", err)
+	}
+	if err := os.Chmod(outputPath, 0600); err != nil {
+		t.Fatalf("Failed to chmod output file: %v", err)
+	}
+
+	if _, err := Run([]string{"zep", "--backup", "-o", outputPath, templatePath}, []string{"VERSION=2"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := Run([]string{"zep", "--backup", "--backup-suffix", ".orig", "-o", outputPath, templatePath}, []string{"VERSION=3"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	wantContent := map[string]string{
+		outputPath:           "version 3",
+		outputPath + ".bak":  "version 1",
+		outputPath + ".orig": "version 2",
+	}
+	for path, want := range wantContent {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", path, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", path, data, want)
+		}
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().Perm() != 0600 {
+			t.Errorf("Expected permissions 0600 for %s but got %o", path, info.Mode().Perm())
+		}
+	}
+
+	// a failed render neither replaces the output nor the backup
+	if _, err := Run([]string{"zep", "--backup", "-o", outputPath, templatePath}, []string{}); err == nil {
+		t.Errorf("Expected render error but got none")
+	}
+	if data, _ := os.ReadFile(outputPath + ".bak"); string(data) != "version 1" {
+		t.Errorf("Expected backup to be unchanged but got %q", data)
+	}
+
+	if _, err := Run([]string{"zep", "--backup", templatePath}, []string{"VERSION=1"}); err == nil {
+		t.Errorf("Expected usage error for --backup without -o but got none")
+	}
+}
+
+func TestRunExportEnv(t *testing.T) {
+	environ := []string{"PORT=8080", "NAME=my app", "PROD_PORT=443", "EMPTY="}
+	output, err := Run([]string{"zep", "--export-env", "--profile", "PROD"}, environ)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "EMPTY=\nNAME='my app'\nPORT=443\nPROD_PORT=443\n" {
+		t.Errorf("Unexpected export %q", output)
+	}
+
+	output, err = Run([]string{"zep", "--export-env", "--fail-on-empty"}, environ)
+	if err != nil || strings.Contains(output, "EMPTY") {
+		t.Errorf("Expected empty values to be left out but got %q, %v", output, err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "effective.env")
+	if _, err := Run([]string{"zep", "--export-env", "-o", outputPath}, []string{"PORT=8080"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil || string(data) != "PORT=8080\n" {
+		t.Errorf("Expected exported file but got %q, %v", data, err)
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the exported file to be private but got %v, %v", info.Mode().Perm(), err)
+	}
+
+	// keys a shell cannot read back are skipped with a warning
+	var captured strings.Builder
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = &captured
+	output, err = Run([]string{"zep", "--export-env"}, []string{"PORT=8080", "BASH_FUNC_greet%%=() {  echo hi\n}"})
+	if err != nil || output != "PORT=8080\n" {
+		t.Errorf("Expected the invalid key to be skipped but got %q, %v", output, err)
+	}
+	if !strings.Contains(captured.String(), "warning: skipping 'BASH_FUNC_greet%%'") {
+		t.Errorf("Expected a warning for the skipped key but got %q", captured.String())
+	}
+
+	invalidArgs := [][]string{
+		{"zep", "--export-env", "template.txt"},
+		{"zep", "--export-env", "--watch", "-o", outputPath},
+		{"zep", "--export-env", "--dir", "src", "--out", "out"},
+	}
+	for _, args := range invalidArgs {
+		if _, err := Run(args, []string{}); err == nil {
+			t.Errorf("Expected error for arguments %v but got none", args)
+		}
+	}
+}
+
+func TestRenderToFileAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "out.conf")
+
+	// large enough to be written in many chunks
+	templateContent := `{{ range asStringSlice "ITEMS" "," }}{{ asString "LINE" }}{{ end }}`
+	items := strings.TrimSuffix(strings.Repeat("x,", 2000), ",")
+	oldLine := strings.Repeat("o", 99) + "\n"
+	newLine := strings.Repeat("n", 99) + "\n"
+	if err := os.WriteFile(templatePath, []byte(templateContent), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+	if err := os.WriteFile(outputPath, []byte(strings.Repeat(oldLine, 2000)), 0644); err != nil {
+		t.Fatalf("Failed to create output file: %v", err)
+	}
+
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(data) != strings.Repeat(oldLine, 2000) && string(data) != strings.Repeat(newLine, 2000) {
+				errs <- fmt.Errorf("read partial output of %d bytes", len(data))
+				return
+			}
+		}
+	}()
+
+	for range 20 {
+		env := Environment{"ITEMS": items, "LINE": newLine}
+		if err := renderToFile(templatePath, outputPath, env, Options{}); err != nil {
+			t.Fatalf("renderToFile returned error: %v", err)
+		}
+		env["LINE"] = oldLine
+		if err := renderToFile(templatePath, outputPath, env, Options{}); err != nil {
+			t.Fatalf("renderToFile returned error: %v", err)
+		}
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Errorf("Reader observed a non-atomic write: %v", err)
+	}
+
+	// a failing render leaves neither a changed target nor a temporary file behind
+	if err := renderToFile(templatePath, outputPath, Environment{"ITEMS": items}, Options{}); err == nil {
+		t.Errorf("Expected render error but got none")
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Expected only the template and output file, got %v", entries)
+	}
+	data, _ := os.ReadFile(outputPath)
+	if string(data) != strings.Repeat(oldLine, 2000) {
+		t.Errorf("Expected output file to be unchanged after a failed render")
+	}
+}
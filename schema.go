@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVariable declares the type of an environment variable and whether it must be set
+type SchemaVariable struct {
+	// Type is one of string, int, bool, url, port or duration, it defaults to string
+	Type string `yaml:"type"`
+	// Required makes a missing variable a violation, optional variables are only checked when set
+	Required bool `yaml:"required"`
+}
+
+// Schema maps environment variable names to their declaration
+type Schema map[string]SchemaVariable
+
+// schemaValidators check a value of each schema type with the accessor of that type, which panics on failure
+var schemaValidators = map[string]func(env Environment, key string){
+	"string":   func(env Environment, key string) { env.AsString(key) },
+	"int":      func(env Environment, key string) { env.AsInt(key) },
+	"bool":     func(env Environment, key string) { env.AsBool(key) },
+	"url":      func(env Environment, key string) { env.AsURL(key) },
+	"port":     func(env Environment, key string) { env.AsPort(key) },
+	"duration": func(env Environment, key string) { env.AsDuration(key) },
+}
+
+// LoadSchema reads a schema from a YAML file that maps variable names to their type and required flag:
+//
+//	DB_HOST:
+//	  required: true
+//	DB_PORT:
+//	  type: port
+//	  required: true
+//	TIMEOUT:
+//	  type: duration
+//
+// Unknown fields and types are an error.
+func LoadSchema(path string) (Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema file '%s': %v", path, err)
+	}
+
+	schema := Schema{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&schema); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing schema file '%s': %v", path, err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(schema)) {
+		variable := schema[key]
+		if variable.Type == "" {
+			variable.Type = "string"
+			schema[key] = variable
+		}
+		if _, ok := schemaValidators[variable.Type]; !ok {
+			return nil, fmt.Errorf("error parsing schema file '%s': unknown type '%s' for '%s'", path, variable.Type, key)
+		}
+	}
+	return schema, nil
+}
+
+// Validate checks the environment against the schema and reports every violation at once,
+// one per line in alphabetical order of the variable names
+func (schema Schema) Validate(env Environment) error {
+	var violations []error
+	for _, key := range slices.Sorted(maps.Keys(schema)) {
+		variable := schema[key]
+		if _, ok := env[key]; !ok {
+			if variable.Required {
+				violations = append(violations, fmt.Errorf("required variable '%s' is not set", key))
+			}
+			continue
+		}
+		if err := validateVariable(env, key, variable.Type); err != nil {
+			violations = append(violations, err)
+		}
+	}
+	return errors.Join(violations...)
+}
+
+// validateVariable runs the validator of the type and turns its panic into an error
+func validateVariable(env Environment, key, typ string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	schemaValidators[typ](env, key)
+	return nil
+}
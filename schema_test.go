@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSchema(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create schema file: %v", err)
+	}
+	return path
+}
+
+func TestLoadSchema(t *testing.T) {
+	path := writeSchema(t, `
+DB_HOST:
+  required: true
+DB_PORT:
+  type: port
+  required: true
+TIMEOUT:
+  type: duration
+`)
+	schema, err := LoadSchema(path)
+	if err != nil {
+		t.Fatalf("LoadSchema returned error: %v", err)
+	}
+	want := Schema{
+		"DB_HOST": {Type: "string", Required: true},
+		"DB_PORT": {Type: "port", Required: true},
+		"TIMEOUT": {Type: "duration", Required: false},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("LoadSchema = %v, want %v", schema, want)
+	}
+
+	invalid := map[string]string{
+		"unknown type":  "PORT:\n  type: number\n",
+		"unknown field": "PORT:\n  type: port\n  requried: true\n",
+		"not a mapping": "- PORT\n",
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadSchema(writeSchema(t, content)); err == nil {
+				t.Errorf("expected error for schema %q", content)
+			}
+		})
+	}
+
+	if _, err := LoadSchema(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("expected error for missing schema file")
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := Schema{
+		"DEBUG":   {Type: "bool"},
+		"DB_HOST": {Type: "string", Required: true},
+		"DB_PORT": {Type: "port", Required: true},
+		"DB_URL":  {Type: "url"},
+		"TIMEOUT": {Type: "duration"},
+		"WORKERS": {Type: "int"},
+	}
+
+	valid := Environment{"DB_HOST": "db", "DB_PORT": "5432", "DB_URL": "postgres://db/app", "TIMEOUT": "5s", "WORKERS": "4", "DEBUG": "yes"}
+	if err := schema.Validate(valid); err != nil {
+		t.Errorf("Validate returned error for a valid environment: %v", err)
+	}
+
+	optional := Environment{"DB_HOST": "db", "DB_PORT": "5432"}
+	if err := schema.Validate(optional); err != nil {
+		t.Errorf("Validate returned error for missing optional variables: %v", err)
+	}
+
+	invalid := Environment{"DB_PORT": "70000", "TIMEOUT": "soon", "WORKERS": "four", "DEBUG": "maybe"}
+	err := schema.Validate(invalid)
+	if err == nil {
+		t.Fatalf("Validate returned no error for an invalid environment")
+	}
+	want := "required variable 'DB_HOST' is not set\n" +
+		"port 'DB_PORT' (value: '70000') is out of range (1-65535)\n" +
+		"could not parse 'DEBUG' (value: 'maybe') as boolean\n" +
+		"could not parse 'TIMEOUT' (value: 'soon') as duration: time: invalid duration \"soon\"\n" +
+		"could not parse 'WORKERS' (value: 'four') as integer: strconv.Atoi: parsing \"four\": invalid syntax"
+	if err.Error() != want {
+		t.Errorf("Validate error = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestRunSchema(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	if err := os.WriteFile(templatePath, []byte("listen {{ .PORT }};"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+	schemaPath := writeSchema(t, "PORT:\n  type: port\n  required: true\n")
+
+	output, err := Run([]string{"zep", "--schema", schemaPath, templatePath}, []string{"PORT=8080"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "listen 8080;" {
+		t.Errorf("Expected output %q but got %q", "listen 8080;", output)
+	}
+
+	invalidEnvirons := [][]string{
+		{},
+		{"PORT=http"},
+		{"PORT="},
+	}
+	for _, environ := range invalidEnvirons {
+		_, err := Run([]string{"zep", "--schema", schemaPath, templatePath}, environ)
+		if err == nil || !contains(err.Error(), "does not match schema") {
+			t.Errorf("Expected schema error for environment %v but got %v", environ, err)
+		}
+	}
+
+	_, err = Run([]string{"zep", "--schema", schemaPath, "--fail-on-empty", templatePath}, []string{"PORT= "})
+	if err == nil || !contains(err.Error(), "required variable 'PORT' is not set") {
+		t.Errorf("Expected required error for empty value but got %v", err)
+	}
+}
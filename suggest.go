@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// undefinedFunction matches the parse error text/template reports for an unknown function
+var undefinedFunction = regexp.MustCompile(`function "([^"]+)" not defined`)
+
+// levenshtein returns the number of single rune insertions, deletions and substitutions that turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// closestName returns the candidate most likely meant by a mistyped name, or an empty string if none is close.
+// Candidates that start with name, such as asString for asStr, are preferred and the shortest of them wins.
+// Otherwise the candidate with the smallest case-insensitive edit distance wins if the distance is at most
+// half the length of name. Ties go to the candidate that comes first in candidates.
+func closestName(name string, candidates []string) string {
+	lower := strings.ToLower(name)
+	best, bestDistance := "", 0
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), lower) && (best == "" || len(candidate) < len(best)) {
+			best = candidate
+		}
+	}
+	if best != "" {
+		return best
+	}
+	for _, candidate := range candidates {
+		distance := levenshtein(lower, strings.ToLower(candidate))
+		if distance <= max(1, len(name)/2) && (best == "" || distance < bestDistance) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// suggestFunction extends the parse error for an undefined function with the closest of the registered
+// function names. Other errors, and undefined functions without a close match, are returned unchanged.
+func suggestFunction(err error, names []string) error {
+	match := undefinedFunction.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	suggestion := closestName(match[1], names)
+	if suggestion == "" {
+		return err
+	}
+	return fmt.Errorf("%w, did you mean \"%s\"?", err, suggestion)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_levenshtein(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		wanted int
+	}{
+		{a: "", b: "", wanted: 0},
+		{a: "abc", b: "", wanted: 3},
+		{a: "", b: "abc", wanted: 3},
+		{a: "kitten", b: "sitting", wanted: 3},
+		{a: "asStr", b: "asString", wanted: 3},
+		{a: "toupper", b: "toUpper", wanted: 1},
+		{a: "日本語", b: "日本", wanted: 1},
+	}
+
+	for _, tc := range tests {
+		if got := levenshtein(tc.a, tc.b); got != tc.wanted {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.wanted)
+		}
+	}
+}
+
+func Test_closestName(t *testing.T) {
+	candidates := []string{"asInt", "asString", "asStringOr", "asURL", "contains", "toLower", "toUpper", "trim"}
+
+	tests := []struct {
+		name   string
+		wanted string
+	}{
+		{name: "asStr", wanted: "asString"},
+		{name: "asstring", wanted: "asString"},
+		{name: "toupper", wanted: "toUpper"},
+		{name: "contians", wanted: "contains"},
+		{name: "asInteger", wanted: "asInt"},
+		{name: "trm", wanted: "trim"},
+		{name: "frobnicate", wanted: ""},
+		{name: "x", wanted: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := closestName(tc.name, candidates); got != tc.wanted {
+				t.Errorf("closestName(%q) = %q, want %q", tc.name, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateSuggestsFunction(t *testing.T) {
+	env := Environment{"NAME": "zep"}
+	for _, html := range []bool{false, true} {
+		_, err := RenderTemplateWithOptions("app.conf", "a\n{{ asStr \"NAME\" }}", env, Options{HTML: html})
+		if err == nil || !strings.HasSuffix(err.Error(), `function "asStr" not defined, did you mean "asString"?`) {
+			t.Errorf("Expected a suggestion (HTML: %v) but got %v", html, err)
+		}
+	}
+
+	_, err := RenderTemplate(`{{ frobnicate }}`, env)
+	if err == nil || !strings.HasSuffix(err.Error(), `function "frobnicate" not defined`) {
+		t.Errorf("Expected the error to be unchanged without a close match but got %v", err)
+	}
+
+	_, err = RenderTemplate(`{{ tpl "{{ toupper .NAME }}" }}`, env)
+	if err == nil || !strings.Contains(err.Error(), `did you mean "toUpper"?`) {
+		t.Errorf("Expected a suggestion inside tpl but got %v", err)
+	}
+
+	other := errors.New("template: x:1: unexpected EOF")
+	if suggestFunction(other, []string{"asString"}) != other {
+		t.Errorf("Expected other errors to be returned unchanged")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+)
+
+// collapseBlankLines replaces every run of blank or whitespace only lines with a single empty line
+func collapseBlankLines(output string) string {
+	lines := strings.Split(output, "\n")
+	result := make([]string, 0, len(lines))
+	previousBlank := false
+	for i, line := range lines {
+		blank := strings.TrimSpace(line) == ""
+		// the text after the final newline is not a line of its own
+		if blank && i == len(lines)-1 && line == "" {
+			result = append(result, line)
+			break
+		}
+		if blank {
+			if !previousBlank {
+				// keep the carriage return of a CRLF line ending
+				result = append(result, line[len(strings.TrimRight(line, "\r")):])
+			}
+		} else {
+			result = append(result, line)
+		}
+		previousBlank = blank
+	}
+	return strings.Join(result, "\n")
+}
+
+// trimsOutput reports whether any of the whitespace options is set, which requires buffering the output
+func trimsOutput(opts Options) bool {
+	return opts.Trim || opts.TrimBlankLines || opts.EnsureNewline || opts.NoTrailingNewline
+}
+
+// trimOutput applies the whitespace options to the rendered output
+func trimOutput(output string, opts Options) string {
+	if opts.TrimBlankLines {
+		output = collapseBlankLines(output)
+	}
+	if opts.Trim {
+		output = strings.TrimSpace(output)
+	}
+	if opts.EnsureNewline || opts.NoTrailingNewline {
+		output = strings.TrimRight(output, "\r\n")
+	}
+	if opts.EnsureNewline {
+		output += "\n"
+	}
+	return output
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollapseBlankLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "no blank lines", output: "a\nb\n", want: "a\nb\n"},
+		{name: "single blank line", output: "a\n\nb\n", want: "a\n\nb\n"},
+		{name: "run of blank lines", output: "a\n\n\n\nb\n", want: "a\n\nb\n"},
+		{name: "whitespace only lines", output: "a\n  \n\t\n\nb", want: "a\n\nb"},
+		{name: "leading blank lines", output: "\n\n\na", want: "\na"},
+		{name: "trailing blank lines", output: "a\n\n\n", want: "a\n\n"},
+		{name: "crlf", output: "a\r\n\r\n\r\nb\r\n", want: "a\r\n\r\nb\r\n"},
+		{name: "empty", output: "", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := collapseBlankLines(tc.output)
+			if got != tc.want {
+				t.Errorf("collapseBlankLines(%q) = %q, want %q", tc.output, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateTrim(t *testing.T) {
+	env := Environment{"HOSTS": "a,b"}
+	templateContent := `
+{{ range asStringSlice "HOSTS" "," }}
+
+server {{ . }};
+
+{{ end }}
+`
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{name: "disabled", opts: Options{}, want: "\n\n\nserver a;\n\n\n\nserver b;\n\n\n"},
+		{name: "trim", opts: Options{Trim: true}, want: "server a;\n\n\n\nserver b;"},
+		{name: "trim blank lines", opts: Options{TrimBlankLines: true}, want: "\nserver a;\n\nserver b;\n\n"},
+		{name: "both", opts: Options{Trim: true, TrimBlankLines: true}, want: "server a;\n\nserver b;"},
+		{name: "annotated", opts: Options{Trim: true, TrimBlankLines: true, AnnotateSource: true, CommentPrefix: "#"}, want: "# [config] server a;\n# [config]\n# [config] server b;"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderTemplateWithOptions("config", templateContent, env, tc.opts)
+			if err != nil {
+				t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("RenderTemplateWithOptions = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunTrim(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	if err := os.WriteFile(templatePath, []byte("\n  {{ .NAME }}\n\n\n!\n\n"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	output, err := Run([]string{"zep", "--trim", "--trim-blank-lines", templatePath}, []string{"NAME=zep"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "zep\n\n!" {
+		t.Errorf("Expected output %q but got %q", "zep\n\n!", output)
+	}
+}
+
+func TestRenderTemplateTrailingNewline(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		ensure string
+		strip  string
+	}{
+		{name: "none", output: "a", ensure: "a\n", strip: "a"},
+		{name: "one", output: "a\n", ensure: "a\n", strip: "a"},
+		{name: "multiple", output: "a\n\n\n", ensure: "a\n", strip: "a"},
+		{name: "crlf", output: "a\r\n\r\n", ensure: "a\n", strip: "a"},
+		{name: "inner newlines kept", output: "a\n\nb\n\n", ensure: "a\n\nb\n", strip: "a\n\nb"},
+		{name: "empty", output: "", ensure: "\n", strip: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderTemplateWithOptions("config", tc.output, Environment{}, Options{EnsureNewline: true})
+			if err != nil || got != tc.ensure {
+				t.Errorf("EnsureNewline = %q, %v, want %q", got, err, tc.ensure)
+			}
+			got, err = RenderTemplateWithOptions("config", tc.output, Environment{}, Options{NoTrailingNewline: true})
+			if err != nil || got != tc.strip {
+				t.Errorf("NoTrailingNewline = %q, %v, want %q", got, err, tc.strip)
+			}
+			got, err = RenderTemplateWithOptions("config", tc.output, Environment{}, Options{})
+			if err != nil || got != tc.output {
+				t.Errorf("default = %q, %v, want %q", got, err, tc.output)
+			}
+		})
+	}
+}
+
+func TestRunTrailingNewline(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "out.conf")
+	if err := os.WriteFile(templatePath, []byte("{{ .NAME }}\n\n"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	tests := []struct {
+		flag string
+		want string
+	}{
+		{flag: "--ensure-newline", want: "zep\n"},
+		{flag: "--no-trailing-newline", want: "zep"},
+		{flag: "--no-newline", want: "zep\n\n"},
+		{flag: "-n", want: "zep\n\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.flag, func(t *testing.T) {
+			if _, err := Run([]string{"zep", tc.flag, "-o", outputPath, templatePath}, []string{"NAME=zep"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			data, err := os.ReadFile(outputPath)
+			if err != nil || string(data) != tc.want {
+				t.Errorf("Expected output file %q but got %q, %v", tc.want, data, err)
+			}
+
+			var stdout strings.Builder
+			if err := RunTo(&stdout, []string{"zep", tc.flag, templatePath}, []string{"NAME=zep"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stdout.String() != tc.want {
+				t.Errorf("Expected stdout %q but got %q", tc.want, stdout.String())
+			}
+
+			// Run only leaves out a newline it appended itself
+			output, err := Run([]string{"zep", tc.flag, templatePath}, []string{"NAME=zep"})
+			if err != nil || output != tc.want {
+				t.Errorf("Expected Run to return %q but got %q, %v", tc.want, output, err)
+			}
+		})
+	}
+
+	// without a flag only stdout gets the extra newline
+	if _, err := Run([]string{"zep", "-o", outputPath, templatePath}, []string{"NAME=zep"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(outputPath); err != nil || string(data) != "zep\n\n" {
+		t.Errorf("Expected output file %q but got %q, %v", "zep\n\n", data, err)
+	}
+	var stdout strings.Builder
+	if err := RunTo(&stdout, []string{"zep", templatePath}, []string{"NAME=zep"}); err != nil || stdout.String() != "zep\n\n\n" {
+		t.Errorf("Expected stdout %q but got %q, %v", "zep\n\n\n", stdout.String(), err)
+	}
+	if output, err := Run([]string{"zep", templatePath}, []string{"NAME=zep"}); err != nil || output != "zep\n\n" {
+		t.Errorf("Expected Run to return %q but got %q, %v", "zep\n\n", output, err)
+	}
+
+	if _, err := Run([]string{"zep", "--ensure-newline", "--no-trailing-newline", templatePath}, []string{}); err == nil {
+		t.Errorf("Expected usage error for conflicting flags but got none")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// numberValue matches the values --set turns into numbers instead of strings
+var numberValue = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+
+// setFlag collects the values of a repeatable flag
+type setFlag []string
+
+func (s *setFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *setFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// coerceValue turns true and false into booleans and decimal numbers into an int, or a float64 if they
+// have a fractional part or do not fit into an int. Everything else stays a string
+func coerceValue(s string) any {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if !numberValue.MatchString(s) {
+		return s
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// parseSetValues builds nested values from a.b.c=value assignments, applied in order so a later
+// assignment of the same path wins. Values are converted with coerceValue.
+// A path segment may not be empty, and a path cannot extend a value that is not a map, so after a.b=1,
+// a.b.c=2 is an error, while a.b=1 replaces a map assigned to a.b by an earlier a.b.c=2.
+func parseSetValues(assignments []string) (map[string]any, error) {
+	values := map[string]any{}
+	for _, assignment := range assignments {
+		path, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid value '%s', expected path=value", assignment)
+		}
+		segments := strings.Split(path, ".")
+		if slices.Contains(segments, "") {
+			return nil, fmt.Errorf("invalid value '%s', the path has an empty segment", assignment)
+		}
+		current := values
+		for i, segment := range segments[:len(segments)-1] {
+			next, exists := current[segment]
+			if !exists {
+				child := map[string]any{}
+				current[segment] = child
+				current = child
+				continue
+			}
+			child, isMap := next.(map[string]any)
+			if !isMap {
+				return nil, fmt.Errorf("invalid value '%s', '%s' is already set to %v", assignment, strings.Join(segments[:i+1], "."), next)
+			}
+			current = child
+		}
+		current[segments[len(segments)-1]] = coerceValue(value)
+	}
+	return values, nil
+}
+
+// templateData returns the value templates are executed with, a map holding the environment variables,
+// so {{ .PORT }} keeps working, and, if set, the values under Values. Values shadows an environment
+// variable of the same name, which stays readable through the accessor functions such as asString.
+// The environment as a whole is available through the envMap function
+func templateData(env Environment, values map[string]any) map[string]any {
+	data := make(map[string]any, len(env)+1)
+	for k, v := range env {
+		data[k] = v
+	}
+	if values != nil {
+		data["Values"] = values
+	}
+	return data
+}
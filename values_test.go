@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_coerceValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		wanted any
+	}{
+		{value: "true", wanted: true},
+		{value: "false", wanted: false},
+		{value: "True", wanted: "True"},
+		{value: "42", wanted: 42},
+		{value: "-7", wanted: -7},
+		{value: "0", wanted: 0},
+		{value: "1.5", wanted: 1.5},
+		{value: "99999999999999999999", wanted: 99999999999999999999.0},
+		{value: "007", wanted: "007"},
+		{value: "1e3", wanted: "1e3"},
+		{value: "NaN", wanted: "NaN"},
+		{value: "1.", wanted: "1."},
+		{value: "", wanted: ""},
+		{value: "text", wanted: "text"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			if got := coerceValue(tc.value); got != tc.wanted {
+				t.Errorf("coerceValue(%q) = %#v, want %#v", tc.value, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func Test_parseSetValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		sets    []string
+		wanted  map[string]any
+		wantErr bool
+	}{
+		{
+			name: "nested",
+			sets: []string{"image.repository=nginx", "image.tag=1.25", "replicas=3", "debug=false"},
+			wanted: map[string]any{
+				"image":    map[string]any{"repository": "nginx", "tag": 1.25},
+				"replicas": 3,
+				"debug":    false,
+			},
+		},
+		{
+			name:   "value containing equals",
+			sets:   []string{"db.dsn=host=db user=app"},
+			wanted: map[string]any{"db": map[string]any{"dsn": "host=db user=app"}},
+		},
+		{
+			name:   "later assignment wins",
+			sets:   []string{"a.b=1", "a.b=2"},
+			wanted: map[string]any{"a": map[string]any{"b": 2}},
+		},
+		{
+			name:   "value replaces map",
+			sets:   []string{"a.b.c=1", "a.b=2"},
+			wanted: map[string]any{"a": map[string]any{"b": 2}},
+		},
+		{name: "path through value", sets: []string{"a.b=1", "a.b.c=2"}, wantErr: true},
+		{name: "missing equals", sets: []string{"a.b"}, wantErr: true},
+		{name: "empty segment", sets: []string{"a..b=1"}, wantErr: true},
+		{name: "empty path", sets: []string{"=1"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSetValues(tc.sets)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseSetValues(%q) error = %v, want error %v", tc.sets, err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.wanted) {
+				t.Errorf("parseSetValues(%q) = %v, want %v", tc.sets, got, tc.wanted)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateValues(t *testing.T) {
+	env := Environment{"PORT": "8080", "Values": "shadowed"}
+	values := map[string]any{"image": map[string]any{"tag": "1.25"}, "replicas": 3}
+	templateContent := `{{ .Values.image.tag }} {{ .Values.replicas }} {{ .PORT }} {{ asString "Values" }}`
+	funcs := `{{ define "part" }}{{ .Values.replicas }}{{ end }}{{ include "part" . }}`
+
+	got, err := RenderTemplateWithOptions("t", templateContent+funcs, env, Options{Values: values})
+	if err != nil {
+		t.Fatalf("RenderTemplateWithOptions returned error: %v", err)
+	}
+	if got != "1.25 3 8080 shadowed3" {
+		t.Errorf("RenderTemplateWithOptions = %q", got)
+	}
+
+	got, err = RenderTemplate(`{{ .Values }}`, env)
+	if err != nil || got != "shadowed" {
+		t.Errorf("Expected the environment variable without values but got %q, %v", got, err)
+	}
+}
+
+func TestRenderTemplateEnvMap(t *testing.T) {
+	env := Environment{"PORT": "8080", "Env": "set", "EMPTY": ""}
+
+	got, err := RenderTemplate(`{{ (envMap).PORT }} {{ .PORT }} {{ .Env }} {{ len envMap }}`, env)
+	if err != nil || got != "8080 8080 set 3" {
+		t.Errorf("RenderTemplate = %q, %v", got, err)
+	}
+
+	got, err = RenderTemplate(`{{ range $k, $v := envMap }}{{ $k }},{{ end }}|{{ toBatchEnv envMap }}`, Environment{"A": "1", "B": "2"})
+	if err != nil || got != "A,B,|set \"A=1\"\nset \"B=2\"\n" {
+		t.Errorf("RenderTemplate = %q, %v", got, err)
+	}
+
+	got, err = RenderTemplateWithOptions("t", `{{ len envMap }}`, env, Options{FailOnEmpty: true})
+	if err != nil || got != "2" {
+		t.Errorf("Expected envMap to leave out empty values with FailOnEmpty but got %q, %v", got, err)
+	}
+}
+
+func TestRenderTemplateRangeDot(t *testing.T) {
+	// dot holds only the environment variables
+	got, err := RenderTemplate("{{ range $k, $v := . }}{{ $k }}={{ $v }}\n{{ end }}{{ len . }}", Environment{"A": "1", "B": "2"})
+	if err != nil || got != "A=1\nB=2\n2" {
+		t.Errorf("RenderTemplate = %q, %v", got, err)
+	}
+}
+
+func TestRunSet(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestFiles(t, tempDir, map[string]string{"template.txt": `{{ .Values.app.name }}:{{ .Values.app.port }}:{{ if .Values.debug }}debug{{ end }}`})
+	templatePath := filepath.Join(tempDir, "template.txt")
+
+	output, err := Run([]string{"zep", "--set", "app.name=zep", "--set=app.port=8080", templatePath, "--set", "debug=true"}, []string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "zep:8080:debug" {
+		t.Errorf("Unexpected output %q", output)
+	}
+
+	if _, err := Run([]string{"zep", "--set", "a=1", "--set", "a.b=2", templatePath}, []string{}); err == nil || !strings.Contains(err.Error(), "a.b=2") {
+		t.Errorf("Expected error for conflicting values but got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, populated at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// versionString returns the version, git commit, build date and Go runtime version.
+func versionString() string {
+	return fmt.Sprintf("zep %s (commit: %s, built: %s, %s)", version, commit, buildDate, runtime.Version())
+}
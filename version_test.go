@@ -0,0 +1,42 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	originalVersion, originalCommit, originalBuildDate := version, commit, buildDate
+	defer func() { version, commit, buildDate = originalVersion, originalCommit, originalBuildDate }()
+
+	version, commit, buildDate = "1.2.3", "abc1234", "2025-01-01T00:00:00Z"
+
+	want := "zep 1.2.3 (commit: abc1234, built: 2025-01-01T00:00:00Z, " + runtime.Version() + ")"
+	if got := versionString(); got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+}
+
+func TestRunVersion(t *testing.T) {
+	originalVersion, originalCommit, originalBuildDate := version, commit, buildDate
+	defer func() { version, commit, buildDate = originalVersion, originalCommit, originalBuildDate }()
+
+	version, commit, buildDate = "1.2.3", "abc1234", "2025-01-01T00:00:00Z"
+	want := "zep 1.2.3 (commit: abc1234, built: 2025-01-01T00:00:00Z, " + runtime.Version() + ")"
+
+	for _, flag := range []string{"-version", "--version"} {
+		output, err := Run([]string{"zep", flag}, []string{})
+		if err != nil {
+			t.Fatalf("Run(%s) returned error: %v", flag, err)
+		}
+		if output != want {
+			t.Errorf("Run(%s) = %q, want %q", flag, output, want)
+		}
+	}
+
+	// the version is printed without reading the template
+	output, err := Run([]string{"zep", "-version", "missing.tmpl"}, []string{})
+	if err != nil || output != want {
+		t.Errorf("Run(-version missing.tmpl) = %q, %v, want %q", output, err, want)
+	}
+}
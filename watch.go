@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Watch renders templateFile to outputFile and renders it again whenever the template changes on disk.
+// Changes are detected by polling the modification time and size of the template every interval.
+// Render errors are logged to logw and do not stop watching; it returns when ctx is cancelled.
+func Watch(ctx context.Context, templateFile, outputFile string, env Environment, opts Options, interval time.Duration, logw io.Writer) error {
+	var lastModTime time.Time
+	lastSize := int64(-1)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		info, err := os.Stat(templateFile)
+		if err != nil {
+			fmt.Fprintf(logw, "error reading template file '%s': %v\n", templateFile, err)
+		} else if !info.ModTime().Equal(lastModTime) || info.Size() != lastSize {
+			lastModTime = info.ModTime()
+			lastSize = info.Size()
+			if err := renderToFile(templateFile, outputFile, env, opts); err != nil {
+				fmt.Fprintln(logw, err)
+			} else {
+				fmt.Fprintf(logw, "rendered '%s' to '%s'\n", templateFile, outputFile)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
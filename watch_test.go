@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes and reads
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(path); err == nil && string(data) == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	data, _ := os.ReadFile(path)
+	t.Fatalf("Expected %s to contain %q but got %q", path, want, data)
+}
+
+func TestWatch(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "out.conf")
+
+	if err := os.WriteFile(templatePath, []byte("v1 {{ .NAME }}"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	logs := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, templatePath, outputPath, Environment{"NAME": "zep"}, Options{}, 10*time.Millisecond, logs)
+	}()
+
+	waitForFile(t, outputPath, "v1 zep")
+
+	if err := os.WriteFile(templatePath, []byte("{{ .NAME"), 0644); err != nil {
+		t.Fatalf("Failed to update template file: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), "error rendering template") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), "error rendering template") {
+		t.Errorf("Expected render error to be logged but got %q", logs.String())
+	}
+
+	if err := os.WriteFile(templatePath, []byte("version two {{ .NAME }}"), 0644); err != nil {
+		t.Fatalf("Failed to update template file: %v", err)
+	}
+	waitForFile(t, outputPath, "version two zep")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Watch did not return after cancel")
+	}
+}
+
+func TestRunOutputFile(t *testing.T) {
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "template.txt")
+	outputPath := filepath.Join(tempDir, "out.conf")
+
+	if err := os.WriteFile(templatePath, []byte("Hello {{ .NAME }}"), 0644); err != nil {
+		t.Fatalf("Failed to create template file: %v", err)
+	}
+
+	output, err := Run([]string{"zep", "-o", outputPath, templatePath}, []string{"NAME=World"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "" {
+		t.Errorf("Expected empty output but got %q", output)
+	}
+	waitForFile(t, outputPath, "Hello World")
+
+	if err := os.WriteFile(templatePath, []byte("Hello {{ asString \"MISSING\" }}"), 0644); err != nil {
+		t.Fatalf("Failed to update template file: %v", err)
+	}
+	if _, err := Run([]string{"zep", "-o", outputPath, templatePath}, []string{}); err == nil {
+		t.Errorf("Expected error for missing key but got none")
+	}
+	waitForFile(t, outputPath, "Hello World")
+	entries, err := os.ReadDir(tempDir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Expected no temporary files to be left behind, got %v", entries)
+	}
+
+	invalidArgs := [][]string{
+		{"zep", "--watch", templatePath},
+		{"zep", "--watch", "--interval", "0s", "-o", outputPath, templatePath},
+		{"zep", "--interval", "soon", templatePath},
+	}
+	for _, args := range invalidArgs {
+		if _, err := Run(args, []string{}); err == nil {
+			t.Errorf("Expected error for arguments %v but got none", args)
+		}
+	}
+}